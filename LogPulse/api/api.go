@@ -1,11 +1,14 @@
 // Package api is to start the api to be listening on different endpoints.
 // The API will listen on the port specified in the PulseConfig.toml.
-// There are 2 endpoints:
+// There are 4 endpoints:
 // POST /log/file this will read the file line by line passing in each line to the algorithm
-// POST /log/message (in development) this will take a string and pass it directly to the algorithm
+// POST /log/message this will take a string and pass it directly to the algorithm, answering with any anomalies it found
+// POST /anomalies/{id}/feedback this marks an anomaly from /log/message as benign or confirmed
+// GET /patterns this lists the patterns learned from /log/message, most frequent first or by id with ?sort=id
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,6 +16,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -28,8 +34,34 @@ type Result struct {
 	Message string `json:"message"`
 }
 
+// MessageResult is the response to /log/message, with the anomalies the message led to
+type MessageResult struct {
+	Result
+	Anomalies []pulse.Anomaly `json:"anomalies"`
+}
+
+// PatternsResult is the response to /patterns, with the patterns the model has learned
+type PatternsResult struct {
+	Result
+	Patterns []pulse.PatternInfo `json:"patterns"`
+}
+
 var buffStrings []string
 var port int
+var options pulse.Options
+
+// stream is the detector every /log/message is analyzed by, and the one feedback is given to.
+// messageMu keeps the anomalies of one message from being mixed up with those of another,
+// and streamMu guards streamed, which the handler of stream appends to.
+// saves asks saveInBackground to save the model, feedback given while it saves is kept by one more save.
+var (
+	stream    *pulse.Detector
+	saveModel func() error
+	saves     = make(chan struct{}, 1)
+	messageMu sync.Mutex
+	streamMu  sync.Mutex
+	streamed  []pulse.Anomaly
+)
 
 func init() {
 	defer func() {
@@ -43,13 +75,31 @@ func init() {
 		panic(fmt.Errorf("API: %s", err))
 	}
 	port = val.Port
+	if options, err = val.Algorithm.Options(); err != nil {
+		panic(fmt.Errorf("API: %s", err))
+	}
+	if options.Rules, err = val.PulseRules(); err != nil {
+		panic(fmt.Errorf("API: %s", err))
+	}
 }
 
 // Start will run the REST API.
-func Start() {
+// Messages are analyzed by detector, and save is called in the background to keep the model after feedback.
+func Start(detector *pulse.Detector, save func() error) {
+	stream = detector
+	saveModel = save
+	go saveInBackground()
+	stream.SetHandler(func(a pulse.Anomaly) {
+		streamMu.Lock()
+		streamed = append(streamed, a)
+		streamMu.Unlock()
+	})
+
 	http.HandleFunc("/", HelloWorld)
 	http.HandleFunc("/log/message", StreamLog)
 	http.HandleFunc("/log/file", SendFile)
+	http.HandleFunc("/anomalies/", Feedback)
+	http.HandleFunc("/patterns", Patterns)
 
 	fmt.Printf("Listening on localhost:%d\n", port)
 	http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
@@ -80,7 +130,9 @@ func StreamLog(w http.ResponseWriter, r *http.Request) {
 	// If we could not parse it as json then respond with a 400: bad request
 	decoder := json.NewDecoder(r.Body)
 	var body struct {
-		Message string `json:"message"`
+		Message string            `json:"message"`
+		Source  string            `json:"source"`
+		Labels  map[string]string `json:"labels"`
 	}
 	err := decoder.Decode(&body)
 	if err != nil {
@@ -90,13 +142,131 @@ func StreamLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//TODO: post the string to the algorthm for analyzing
+	messageMu.Lock()
+	streamMu.Lock()
+	streamed = nil
+	streamMu.Unlock()
+	stream.AnalyzeRecord(pulse.Record{Source: body.Source, Line: body.Message, Labels: body.Labels})
+	streamMu.Lock()
+	found := streamed
+	streamed = nil
+	streamMu.Unlock()
+	messageMu.Unlock()
 
 	// If we were able to decode and send string to algorithm return a 200: success
+	w.Header().Set("Content-Type", "application/json")
+	result, _ := json.Marshal(MessageResult{Result{200, "success"}, found})
+	io.WriteString(w, string(result))
+
+}
+
+// Feedback listens for a POST to /anomalies/{id}/feedback with a verdict of "benign" or "confirmed"
+// in the body. A benign anomaly is learned so it is no longer reported, a confirmed one is always reported.
+func Feedback(w http.ResponseWriter, r *http.Request) {
+
+	// Checking to see if the request was a post to /anomalies/{id}/feedback.
+	// If not return a 400: bad request
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method != "POST" || len(parts) != 3 || parts[2] != "feedback" {
+		w.Header().Set("Content-Type", "application/json")
+		result, _ := json.Marshal(Result{400, "bad request"})
+		io.WriteString(w, string(result))
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		result, _ := json.Marshal(Result{400, "bad request"})
+		io.WriteString(w, string(result))
+		return
+	}
+
+	// Decoding the body of the response.
+	// If we could not parse it as json then respond with a 400: bad request
+	decoder := json.NewDecoder(r.Body)
+	var body struct {
+		Verdict string `json:"verdict"`
+	}
+	if err := decoder.Decode(&body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		result, _ := json.Marshal(Result{400, "bad request"})
+		io.WriteString(w, string(result))
+		return
+	}
+
+	switch body.Verdict {
+	case "benign":
+		err = stream.MarkBenign(id)
+	case "confirmed":
+		err = stream.MarkConfirmed(id)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		result, _ := json.Marshal(Result{400, "verdict must be benign or confirmed"})
+		io.WriteString(w, string(result))
+		return
+	}
+	if err == pulse.ErrUnknownAnomaly {
+		w.Header().Set("Content-Type", "application/json")
+		result, _ := json.Marshal(Result{404, "anomaly not found"})
+		io.WriteString(w, string(result))
+		return
+	}
+	if err == nil {
+		select {
+		case saves <- struct{}{}:
+		default:
+		}
+	}
+	if err != nil {
+		log.Printf("api.Feedback: %s\n", err)
+		w.Header().Set("Content-Type", "application/json")
+		result, _ := json.Marshal(Result{500, "feedback failed"})
+		io.WriteString(w, string(result))
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	result, _ := json.Marshal(Result{200, "success"})
 	io.WriteString(w, string(result))
+}
 
+// saveInBackground saves the model whenever feedback asks for it, so a feedback does not wait for the save.
+func saveInBackground() {
+	for range saves {
+		if err := saveModel(); err != nil {
+			log.Printf("api.saveInBackground: %s\n", err)
+		}
+	}
+}
+
+// Patterns answers a GET with the patterns the model has learned from /log/message.
+// They are ordered by how many lines they matched, or by id with ?sort=id.
+func Patterns(w http.ResponseWriter, r *http.Request) {
+
+	// Checking to see if the request was a get.
+	// If not return a 400: bad request
+	if r.Method != "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		result, _ := json.Marshal(Result{400, "bad request"})
+		io.WriteString(w, string(result))
+		return
+	}
+
+	patterns := stream.Patterns()
+	switch r.URL.Query().Get("sort") {
+	case "", "frequency":
+		pulse.SortByMatches(patterns)
+	case "id":
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		result, _ := json.Marshal(Result{400, "sort must be frequency or id"})
+		io.WriteString(w, string(result))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	result, _ := json.Marshal(PatternsResult{Result{200, "success"}, patterns})
+	io.WriteString(w, string(result))
 }
 
 // SendFile listens for a POST that has a form field named file and email in the body.
@@ -229,8 +399,9 @@ func SendFile(w http.ResponseWriter, r *http.Request) {
 		}()
 
 		start := time.Now()
-		// Start the pulse algorithm
-		pulse.Run(stdIn, email.SaveToCache)
+		// Start the pulse algorithm with its own model so uploads do not share patterns
+		detector := pulse.New(options)
+		detector.Run(context.Background(), stdIn, email.SaveToCache)
 		line := make(chan string)
 
 		if compressed {
@@ -241,15 +412,19 @@ func SendFile(w http.ResponseWriter, r *http.Request) {
 
 		for l := range line {
 			if l == "EOF" {
-				email.ByPassMail = false
-				// Once EOF, time to send email from cache JSON storage
-				email.SendFromCache(email.OutputFile)
-				close(stdIn)
 				break
 			}
 			stdIn <- l
 		}
 
+		// Wait for the algorithm to finish so every anomaly is in the cache
+		close(stdIn)
+		detector.Wait()
+
+		email.ByPassMail = false
+		// Once EOF, time to send email from cache JSON storage
+		email.SendFromCache(email.OutputFile)
+
 		elapsed := time.Since(start)
 		log.Printf("Pulse Algorithm took %s", elapsed)
 	}()
@@ -4,8 +4,11 @@ package config
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/gophergala2016/Pulse/pulse"
 	"github.com/mitchellh/go-homedir"
 )
 
@@ -25,6 +28,350 @@ type Configuration struct {
 
 	// Port is the port at which the API is to listen on.
 	Port int `toml:"Port"`
+
+	// Algorithm tunes the pulse algorithm. Anything left out uses the pulse default.
+	Algorithm Algorithm `toml:"Algorithm"`
+
+	// Rules silence lines that are known to be noisy or always report lines that must not be missed.
+	Rules []Rule `toml:"Rules"`
+}
+
+// Rule is one [[Rules]] table, see pulse.Rule for what each field does.
+type Rule struct {
+	Name string `toml:"Name"`
+
+	// Action is "ignore" or "alert".
+	Action string `toml:"Action"`
+
+	// Regexp is a Go regular expression matched against the whole line.
+	Regexp string `toml:"Regexp"`
+
+	// Template is matched against the words of the message, with <*> or a typed placeholder such as <INT> for values.
+	Template string `toml:"Template"`
+
+	Source string    `toml:"Source"`
+	Start  time.Time `toml:"Start"`
+	End    time.Time `toml:"End"`
+}
+
+// Algorithm holds the thresholds of the pulse algorithm, see pulse.Options for what each one does.
+type Algorithm struct {
+	TokenMatchRatio     float64  `toml:"TokenMatchRatio"`
+	SimilarityThreshold float64  `toml:"SimilarityThreshold"`
+	UnmatchedTimeout    Duration `toml:"UnmatchedTimeout"`
+	LengthSimilarity    float64  `toml:"LengthSimilarity"`
+	CreationRateGate    float64  `toml:"CreationRateGate"`
+	RateDecay           float64  `toml:"RateDecay"`
+	VertexDistance      int      `toml:"VertexDistance"`
+	VertexPreference    int      `toml:"VertexPreference"`
+
+	// TimeLayouts are Go time layouts for the timestamp at the start of each line.
+	// When set, timeouts and rate decay run in log time instead of wall-clock time.
+	TimeLayouts []string `toml:"TimeLayouts"`
+
+	// DecayInterval applies RateDecay once per interval instead of once per line.
+	DecayInterval Duration `toml:"DecayInterval"`
+
+	// StripHeaders removes syslog, ISO-8601, apache and kernel headers before learning patterns.
+	StripHeaders bool `toml:"StripHeaders"`
+
+	// HeaderTime takes the time of each line from its stripped header.
+	HeaderTime bool `toml:"HeaderTime"`
+
+	// Tokenizer is how lines are split into words: "default", "whitespace" or "structured".
+	Tokenizer string `toml:"Tokenizer"`
+
+	// ReportTypeMismatch reports values that do not fit the type their wildcard has learned.
+	ReportTypeMismatch bool `toml:"ReportTypeMismatch"`
+
+	// KindMinSamples is how many values a wildcard must see before its type is fixed.
+	KindMinSamples int `toml:"KindMinSamples"`
+
+	// ReportOutliers reports numbers and durations far outside the range their wildcard has learned.
+	ReportOutliers bool `toml:"ReportOutliers"`
+
+	// OutlierMinSamples is how many numbers a wildcard must see before outliers in it are reported.
+	OutlierMinSamples int `toml:"OutlierMinSamples"`
+
+	// OutlierQuantile is the fraction of learned values that may lie below, and above, the expected range.
+	OutlierQuantile float64 `toml:"OutlierQuantile"`
+
+	// OutlierDeviations is how many standard deviations from the mean the expected range spans at least.
+	OutlierDeviations float64 `toml:"OutlierDeviations"`
+
+	// ReportNewValues reports values never seen before in a wildcard that holds a small set of values.
+	ReportNewValues bool `toml:"ReportNewValues"`
+
+	// NewValueMaxCardinality is the most distinct values a wildcard may hold for new values in it to be reported.
+	NewValueMaxCardinality int `toml:"NewValueMaxCardinality"`
+
+	// NewValueStable is how many values a wildcard must see without a new one before new values are reported.
+	NewValueStable int64 `toml:"NewValueStable"`
+
+	// RarePercentile reports lines matching the rarest fraction of patterns, 0 turns it off.
+	RarePercentile float64 `toml:"RarePercentile"`
+
+	// RareWarmup is how many lines are read before rare patterns are reported.
+	RareWarmup int64 `toml:"RareWarmup"`
+
+	// RateWindow counts the matches of each pattern in windows of this length, 0 turns it off.
+	RateWindow Duration `toml:"RateWindow"`
+
+	// RateAlpha is the weight of the newest window in the average matches per window.
+	RateAlpha float64 `toml:"RateAlpha"`
+
+	// RateSpike is how many times the average a window must reach to be a spike.
+	RateSpike float64 `toml:"RateSpike"`
+
+	// RateDrop is the fraction of the average a window must fall to to be a drop.
+	RateDrop float64 `toml:"RateDrop"`
+
+	// RateMinBaseline is the average matches per window a pattern needs before drops are reported.
+	RateMinBaseline float64 `toml:"RateMinBaseline"`
+
+	// RateWarmup is how many windows a pattern must be seen for before its rate is reported.
+	RateWarmup int `toml:"RateWarmup"`
+
+	// MaxPatterns is the most patterns kept, 0 keeps every pattern.
+	MaxPatterns int `toml:"MaxPatterns"`
+
+	// Eviction picks the pattern forgotten when MaxPatterns is reached, "lru" or "lfu".
+	Eviction string `toml:"Eviction"`
+
+	// MaxUnmatched is the most unmatched lines kept, 0 keeps every line.
+	MaxUnmatched int `toml:"MaxUnmatched"`
+
+	// UnmatchedTTL drops unmatched lines older than this, 0 keeps them forever.
+	UnmatchedTTL Duration `toml:"UnmatchedTTL"`
+
+	// MaxVariations is the most distinct values counted for each wildcard, 0 counts every value.
+	MaxVariations int `toml:"MaxVariations"`
+
+	// Candidates is the most unmatched lines each new line is compared to, 0 compares it to all of them.
+	Candidates int `toml:"Candidates"`
+
+	// MergeSimilarity is the fraction of words two patterns must share for Consolidate to merge them.
+	MergeSimilarity float64 `toml:"MergeSimilarity"`
+
+	// ConsolidateEvery merges near duplicate patterns after every this many lines, 0 turns it off.
+	ConsolidateEvery int64 `toml:"ConsolidateEvery"`
+
+	// Workers is how many goroutines analyze lines, 0 or 1 analyzes them one at a time.
+	Workers int `toml:"Workers"`
+
+	// Ordered reports anomalies in the order lines were read when Workers is more than 1.
+	Ordered bool `toml:"Ordered"`
+
+	// SplitBySource keeps a separate model for each log file, so their patterns are not mixed.
+	SplitBySource bool `toml:"SplitBySource"`
+
+	// MultilineIndented joins lines whose message starts with whitespace onto the event before them.
+	MultilineIndented bool `toml:"MultilineIndented"`
+
+	// MultilinePrefixes joins lines whose message starts with one of these onto the event before them.
+	MultilinePrefixes []string `toml:"MultilinePrefixes"`
+
+	// MultilineNoHeader joins lines without a recognized header onto the event before them.
+	MultilineNoHeader bool `toml:"MultilineNoHeader"`
+
+	// MultilineMaxIdle ends an event no line has been joined to for this long, 0 waits for the next event.
+	MultilineMaxIdle Duration `toml:"MultilineMaxIdle"`
+
+	// MultilineMaxLines is the most lines joined into one event.
+	MultilineMaxLines int `toml:"MultilineMaxLines"`
+
+	// SequenceOrder reports patterns that seldom follow this many patterns before them, 0 turns it off.
+	SequenceOrder int `toml:"SequenceOrder"`
+
+	// SequenceMinProbability is how likely a pattern must be to follow the patterns before it.
+	SequenceMinProbability float64 `toml:"SequenceMinProbability"`
+
+	// SequenceWarmup is how often the patterns before a line must have been seen before it is reported.
+	SequenceWarmup int64 `toml:"SequenceWarmup"`
+
+	// SequenceTimeout reports patterns not followed within this long by a pattern that nearly always follows them, 0 turns it off.
+	SequenceTimeout Duration `toml:"SequenceTimeout"`
+
+	// SequenceExpected is the fraction of matches another pattern must follow to be expected.
+	SequenceExpected float64 `toml:"SequenceExpected"`
+
+	// SequenceMaxOpen is the most matches of each log file kept waiting for SequenceTimeout.
+	SequenceMaxOpen int `toml:"SequenceMaxOpen"`
+}
+
+// Duration is a time.Duration that is written as a string such as "30s" in the config.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses the duration from the config file.
+func (d *Duration) UnmarshalText(text []byte) error {
+	val, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("config.Duration: %s", err)
+	}
+	d.Duration = val
+	return nil
+}
+
+// PulseRules returns the pulse rules for the [[Rules]] tables.
+func (c *Configuration) PulseRules() ([]pulse.Rule, error) {
+	var rules []pulse.Rule
+	for i, r := range c.Rules {
+		rule := pulse.Rule{Name: r.Name, Template: r.Template, Source: r.Source, Start: r.Start, End: r.End}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		switch r.Action {
+		case "ignore":
+			rule.Action = pulse.ActionIgnore
+		case "alert":
+			rule.Action = pulse.ActionAlert
+		default:
+			return nil, fmt.Errorf("config.PulseRules: %s: Action must be ignore or alert", rule.Name)
+		}
+		if r.Regexp == "" && r.Template == "" {
+			return nil, fmt.Errorf("config.PulseRules: %s: needs a Regexp or a Template", rule.Name)
+		}
+		if r.Regexp != "" {
+			re, err := regexp.Compile(r.Regexp)
+			if err != nil {
+				return nil, fmt.Errorf("config.PulseRules: %s: %s", rule.Name, err)
+			}
+			rule.Regexp = re
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Options returns the pulse options for the algorithm table, using the pulse defaults for anything not set.
+// An unknown Tokenizer or Eviction is an error, as a model must be read with the tokenizer it was learned with.
+func (a Algorithm) Options() (pulse.Options, error) {
+	opts := pulse.DefaultOptions()
+	if a.TokenMatchRatio > 0 {
+		opts.TokenMatchRatio = a.TokenMatchRatio
+	}
+	if a.SimilarityThreshold > 0 {
+		opts.SimilarityThreshold = a.SimilarityThreshold
+	}
+	if a.UnmatchedTimeout.Duration > 0 {
+		opts.UnmatchedTimeout = a.UnmatchedTimeout.Duration
+	}
+	if a.LengthSimilarity > 0 {
+		opts.LengthSimilarity = a.LengthSimilarity
+	}
+	if a.CreationRateGate > 0 {
+		opts.CreationRateGate = a.CreationRateGate
+	}
+	if a.RateDecay > 0 {
+		opts.RateDecay = a.RateDecay
+	}
+	if a.VertexDistance > 0 {
+		opts.VertexDistance = a.VertexDistance
+	}
+	if a.VertexPreference > 0 {
+		opts.VertexPreference = a.VertexPreference
+	}
+	if len(a.TimeLayouts) > 0 {
+		opts.ParseTime = pulse.TimePrefixParser(a.TimeLayouts...)
+	}
+	if a.DecayInterval.Duration > 0 {
+		opts.DecayInterval = a.DecayInterval.Duration
+	}
+	opts.StripHeaders = a.StripHeaders
+	opts.HeaderTime = a.HeaderTime
+	opts.ReportTypeMismatch = a.ReportTypeMismatch
+	if a.KindMinSamples > 0 {
+		opts.KindMinSamples = a.KindMinSamples
+	}
+	opts.ReportOutliers = a.ReportOutliers
+	if a.OutlierMinSamples > 0 {
+		opts.OutlierMinSamples = a.OutlierMinSamples
+	}
+	if a.OutlierQuantile > 0 {
+		opts.OutlierQuantile = a.OutlierQuantile
+	}
+	if a.OutlierDeviations > 0 {
+		opts.OutlierDeviations = a.OutlierDeviations
+	}
+	opts.ReportNewValues = a.ReportNewValues
+	if a.NewValueMaxCardinality > 0 {
+		opts.NewValueMaxCardinality = a.NewValueMaxCardinality
+	}
+	if a.NewValueStable > 0 {
+		opts.NewValueStable = a.NewValueStable
+	}
+	opts.RarePercentile = a.RarePercentile
+	if a.RareWarmup > 0 {
+		opts.RareWarmup = a.RareWarmup
+	}
+	opts.RateWindow = a.RateWindow.Duration
+	if a.RateAlpha > 0 {
+		opts.RateAlpha = a.RateAlpha
+	}
+	if a.RateSpike > 0 {
+		opts.RateSpike = a.RateSpike
+	}
+	if a.RateDrop > 0 {
+		opts.RateDrop = a.RateDrop
+	}
+	if a.RateMinBaseline > 0 {
+		opts.RateMinBaseline = a.RateMinBaseline
+	}
+	if a.RateWarmup > 0 {
+		opts.RateWarmup = a.RateWarmup
+	}
+	opts.MaxPatterns = a.MaxPatterns
+	switch a.Eviction {
+	case "", "lru":
+	case "lfu":
+		opts.Eviction = pulse.EvictLFU
+	default:
+		return opts, fmt.Errorf("config.Options: Eviction must be lru or lfu, not %q", a.Eviction)
+	}
+	opts.MaxUnmatched = a.MaxUnmatched
+	opts.UnmatchedTTL = a.UnmatchedTTL.Duration
+	opts.MaxVariations = a.MaxVariations
+	opts.Candidates = a.Candidates
+	if a.MergeSimilarity > 0 {
+		opts.MergeSimilarity = a.MergeSimilarity
+	}
+	opts.ConsolidateEvery = a.ConsolidateEvery
+	opts.Workers = a.Workers
+	opts.Ordered = a.Ordered
+	opts.SplitBySource = a.SplitBySource
+	opts.Multiline = pulse.Multiline{
+		Indented: a.MultilineIndented,
+		Prefixes: a.MultilinePrefixes,
+		NoHeader: a.MultilineNoHeader,
+		MaxIdle:  a.MultilineMaxIdle.Duration,
+		MaxLines: a.MultilineMaxLines,
+	}
+	opts.SequenceOrder = a.SequenceOrder
+	if a.SequenceMinProbability > 0 {
+		opts.SequenceMinProbability = a.SequenceMinProbability
+	}
+	if a.SequenceWarmup > 0 {
+		opts.SequenceWarmup = a.SequenceWarmup
+	}
+	opts.SequenceTimeout = a.SequenceTimeout.Duration
+	if a.SequenceExpected > 0 {
+		opts.SequenceExpected = a.SequenceExpected
+	}
+	if a.SequenceMaxOpen > 0 {
+		opts.SequenceMaxOpen = a.SequenceMaxOpen
+	}
+	switch a.Tokenizer {
+	case "", "default":
+	case "whitespace":
+		opts.Tokenizer = pulse.WhitespaceTokenizer{}
+	case "structured":
+		opts.Tokenizer = pulse.StructuredTokenizer{}
+	default:
+		return opts, fmt.Errorf("config.Options: Tokenizer must be default, whitespace or structured, not %q", a.Tokenizer)
+	}
+	return opts, nil
 }
 
 // SMTPConfig is the configurations for a personal SMTP server a user would like to use.
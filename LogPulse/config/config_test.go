@@ -2,8 +2,10 @@ package config_test
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/gophergala2016/Pulse/LogPulse/config"
+	"github.com/gophergala2016/Pulse/pulse"
 )
 
 func TestLoad(t *testing.T) {
@@ -52,6 +54,77 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+func TestAlgorithmOptions(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Could not load config. %s", err)
+	}
+	opts, err := cfg.Algorithm.Options()
+	if err != nil {
+		t.Fatalf("Could not read algorithm options. %s", err)
+	}
+	if opts.TokenMatchRatio != 0.6 {
+		t.Errorf("TokenMatchRatio does not match")
+	}
+	if opts.UnmatchedTimeout != time.Minute {
+		t.Errorf("UnmatchedTimeout does not match")
+	}
+	if opts.VertexDistance != 3 {
+		t.Errorf("VertexDistance does not match")
+	}
+
+	if opts.ParseTime == nil {
+		t.Errorf("ParseTime should be set from TimeLayouts")
+	} else if _, ok := opts.ParseTime("Jan 12 06:25:01 host kernel: eth0 up"); !ok {
+		t.Errorf("ParseTime could not read the timestamp")
+	}
+
+	if _, ok := opts.Tokenizer.(pulse.StructuredTokenizer); !ok {
+		t.Errorf("Tokenizer does not match")
+	}
+
+	// Anything not in the config should use the pulse default
+	if opts.RateDecay != pulse.DefaultRateDecay {
+		t.Errorf("RateDecay should be the default")
+	}
+
+	cfg.Algorithm.Tokenizer = "structure"
+	if _, err := cfg.Algorithm.Options(); err == nil {
+		t.Errorf("An unknown tokenizer should be an error")
+	}
+	cfg.Algorithm.Tokenizer = ""
+	cfg.Algorithm.Eviction = "LFU"
+	if _, err := cfg.Algorithm.Options(); err == nil {
+		t.Errorf("An unknown eviction should be an error")
+	}
+}
+
+func TestPulseRules(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Could not load config. %s", err)
+	}
+	rules, err := cfg.PulseRules()
+	if err != nil {
+		t.Fatalf("Could not read rules. %s", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+	if rules[0].Action != pulse.ActionIgnore || rules[0].Regexp == nil || !rules[0].Regexp.MatchString("CRON[1234]: (root) CMD") {
+		t.Errorf("Ignore rule does not match")
+	}
+	if rules[1].Name != "rule 2" || rules[1].Action != pulse.ActionAlert || rules[1].Source != "kern" || rules[1].End.Year() != 2030 {
+		t.Errorf("Alert rule does not match")
+		t.Logf("Actual: %+v", rules[1])
+	}
+
+	cfg.Rules[0].Action = "drop"
+	if _, err := cfg.PulseRules(); err == nil {
+		t.Errorf("An unknown action should be an error")
+	}
+}
+
 func TestLoadSMTP(t *testing.T) {
 	expectedCfg := SMTPConfig{}
 	expectedCfg.Server.Host = "smtp.mailgun.org"
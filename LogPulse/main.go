@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gophergala2016/Pulse/LogPulse/api"
 	"github.com/gophergala2016/Pulse/LogPulse/config"
@@ -15,15 +19,43 @@ import (
 
 var (
 	runAPI      bool
+	modelIn     string
+	modelOut    string
+	consolidate bool
+	freeze      bool
+	sortBy      string
+	command     string
+	fileArgs    []string
 	outputFile  string
 	buffStrings []string
 	logList     []string
+	options     pulse.Options
 )
 
 func init() {
 	flag.BoolVar(&runAPI, "api", false, "Turn on API mode")
+	modelFlags(flag.CommandLine)
 	flag.Parse()
 
+	// train, detect and patterns are subcommands with flags of their own, anything else is a log file
+	fileArgs = flag.Args()
+	if len(fileArgs) > 0 && (fileArgs[0] == "train" || fileArgs[0] == "detect" || fileArgs[0] == "patterns") {
+		command = fileArgs[0]
+		commandFlags := flag.NewFlagSet(command, flag.ExitOnError)
+		switch command {
+		case "patterns":
+			commandFlags.StringVar(&modelIn, "model", "", "The saved model to list the patterns of")
+			commandFlags.StringVar(&sortBy, "sort", "frequency", "Order patterns by frequency or id")
+		case "detect":
+			modelFlags(commandFlags)
+			commandFlags.BoolVar(&freeze, "freeze", false, "Report lines that do not fit the model without learning from them")
+		default:
+			modelFlags(commandFlags)
+		}
+		commandFlags.Parse(fileArgs[1:])
+		fileArgs = commandFlags.Args()
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println(r)
@@ -38,6 +70,20 @@ func init() {
 
 	logList = cfg.LogList
 	outputFile = cfg.OutputFile
+	if options, err = cfg.Algorithm.Options(); err != nil {
+		panic(fmt.Errorf("main.init: %s", err))
+	}
+	options.Freeze = freeze
+	if options.Rules, err = cfg.PulseRules(); err != nil {
+		panic(fmt.Errorf("main.init: %s", err))
+	}
+}
+
+// modelFlags adds the flags that load and save a model to flags.
+func modelFlags(flags *flag.FlagSet) {
+	flags.StringVar(&modelIn, "model", "", "Load a previously saved model before reading the logs")
+	flags.StringVar(&modelOut, "save-model", "", "Save the learned model to this file when done")
+	flags.BoolVar(&consolidate, "consolidate", false, "Merge near duplicate patterns before saving the model")
 }
 
 func main() {
@@ -48,45 +94,211 @@ func main() {
 		}
 	}()
 
-	if len(flag.Args()) == 0 && !runAPI {
+	if runAPI {
+		startAPI()
+		return
+	}
+	if command == "patterns" {
+		if modelIn == "" {
+			panic(fmt.Errorf("main.main: patterns needs a model to list, use -model"))
+		}
+		printPatterns(loadModel())
+		return
+	}
+
+	filenames := fileArgs
+	if len(filenames) == 0 {
 		if len(logList) == 0 {
 			panic(fmt.Errorf("main.main: Must supply a list of log files in the config"))
 		}
-		startPulse(logList)
-	} else if runAPI {
-		startAPI()
-	} else {
-		startPulse(flag.Args())
+		filenames = logList
+	}
+	switch command {
+	case "train":
+		trainPulse(filenames)
+	case "detect":
+		if modelIn == "" {
+			panic(fmt.Errorf("main.main: detect needs a model to detect against, use -model"))
+		}
+		startPulse(filenames)
+	default:
+		startPulse(filenames)
 	}
 }
 
 func startAPI() {
-	api.Start()
+	detector := loadModel()
+	api.Start(detector, func() error { return writeModel(detector) })
 }
 
 func startPulse(filenames []string) {
 	checkList(filenames)
-	stdIn := make(chan string)
+	stdIn := make(chan pulse.Record)
+	ctx := interruptContext()
+
+	detector := loadModel()
+	detector.RunRecords(ctx, stdIn, sendAnomaly)
+	readLogs(ctx, filenames, stdIn)
+	detector.Wait()
+	printRuleHits(detector)
+	saveModel(detector)
+}
+
+// sendAnomaly sends the lines of an anomaly, every line of a multiline event on a line of its own.
+func sendAnomaly(a pulse.Anomaly) {
+	if a.Lines != nil {
+		email.Send(strings.Join(a.Lines, "\n"))
+		return
+	}
+	email.Send(a.Line)
+}
+
+// printRuleHits shows how many lines each rule ignored or alerted on.
+func printRuleHits(detector *pulse.Detector) {
+	for i, hits := range detector.RuleHits() {
+		fmt.Printf("Rule %s matched %d lines\n", options.Rules[i].Name, hits)
+	}
+}
+
+// printPatterns lists what the model has learned, the most common values of each slot under its pattern.
+func printPatterns(detector *pulse.Detector) {
+	patterns := detector.Patterns()
+	switch sortBy {
+	case "frequency":
+		pulse.SortByMatches(patterns)
+	case "id":
+	default:
+		panic(fmt.Errorf("main.printPatterns: -sort must be frequency or id, not %q", sortBy))
+	}
+	for _, p := range patterns {
+		source := ""
+		if p.Source != "" {
+			source = " [" + p.Source + "]"
+		}
+		fmt.Printf("#%d%s matched %d lines, first seen %s, last seen %s\n", p.ID, source, p.Matches, seenTime(p.FirstSeen), seenTime(p.LastSeen))
+		fmt.Printf("    %s\n", p.Template)
+		for _, slot := range p.Slots {
+			values := make([]string, len(slot.Top))
+			for i, v := range slot.Top {
+				values[i] = fmt.Sprintf("%q x%d", v.Value, v.Count)
+			}
+			fmt.Printf("    slot %d %s, %d values: %s\n", slot.Slot, slot.Kind, slot.Distinct, strings.Join(values, ", "))
+		}
+	}
+}
+
+// seenTime formats the time a pattern was seen, models saved before times were kept have none.
+func seenTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// trainPulse learns a model from logs that are known to be good and saves it, without reporting anything.
+func trainPulse(filenames []string) {
+	if modelOut == "" {
+		panic(fmt.Errorf("main.trainPulse: train needs a file to save the model to, use -save-model"))
+	}
+	checkList(filenames)
+	stdIn := make(chan pulse.Record)
+	ctx := interruptContext()
 
+	detector := loadModel()
+	go readLogs(ctx, filenames, stdIn)
+	detector.TrainRecords(stdIn)
+	saveModel(detector)
+}
+
+// interruptContext returns a context that is cancelled on a keyboard interrupt.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
-	// On keyboard interrup cleanup the program
+	// On keyboard interrup stop reading and let pulse finish what it has
 	go func() {
 		for _ = range c {
 			fmt.Println("Exiting for Keyboard Interupt")
-			os.Exit(0)
+			cancel()
 		}
 	}()
+	return ctx
+}
 
-	pulse.Run(stdIn, email.Send)
+// readLogs sends every line of the files to lines, stopping early if ctx is done, then closes lines.
+// Each line is tagged with the name of its file as its source.
+func readLogs(ctx context.Context, filenames []string, lines chan<- pulse.Record) {
+	defer close(lines)
 	for _, filename := range filenames {
 		line := make(chan string)
 		file.Read(filename, line)
+		source := filepath.Base(filename)
 		for l := range line {
-			stdIn <- l
+			select {
+			case lines <- pulse.Record{Source: source, Line: l}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// loadModel returns the detector saved in the -model file, or a new one if no model was given.
+func loadModel() *pulse.Detector {
+	if modelIn == "" {
+		return pulse.New(options)
+	}
+	f, err := os.Open(modelIn)
+	if err != nil {
+		panic(fmt.Errorf("main.loadModel: %s", err))
+	}
+	defer f.Close()
+	detector, err := pulse.LoadWithOptions(f, options)
+	if err != nil {
+		panic(fmt.Errorf("main.loadModel: %s", err))
+	}
+	return detector
+}
+
+// saveModel writes the learned model to the -save-model file if one was given,
+// merging near duplicate patterns first with -consolidate.
+func saveModel(detector *pulse.Detector) {
+	if consolidate {
+		detector.Consolidate()
+	}
+	if err := writeModel(detector); err != nil {
+		panic(fmt.Errorf("main.saveModel: %s", err))
+	}
+}
+
+// writeModel writes the learned model to the -save-model file if one was given.
+// The model is written to a temporary file next to it that is then renamed over it,
+// so the file is never left half written, even if writing fails.
+func writeModel(detector *pulse.Detector) (err error) {
+	if modelOut == "" {
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(modelOut), filepath.Base(modelOut)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
 		}
+	}()
+	// the temporary file is only readable by its owner, the model is readable like any file os.Create makes
+	if err = f.Chmod(0644); err == nil {
+		err = detector.Save(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
 	}
-	close(stdIn)
+	return os.Rename(f.Name(), modelOut)
 }
 
 func checkList(filenames []string) {
@@ -0,0 +1,102 @@
+package pulse
+
+import (
+	"strings"
+	"time"
+)
+
+//Reason explains why a line was reported as an anomaly
+type Reason string
+
+const (
+	//ReasonNeverMatched is used for a line that matched no pattern and no unmatched line when it arrived
+	ReasonNeverMatched Reason = "never_matched"
+	//ReasonTimedOut is used for a line that stayed unmatched longer than the unmatched timeout
+	ReasonTimedOut Reason = "timed_out"
+	//ReasonRarePattern is used for a line that matched a pattern which is seldom seen
+	ReasonRarePattern Reason = "rare_pattern"
+	//ReasonTypeMismatch is used for a line with a value that does not fit the kind its wildcard slot has learned
+	ReasonTypeMismatch Reason = "type_mismatch"
+	//ReasonRateSpike is used for a pattern that matched far more often than usual in a window
+	ReasonRateSpike Reason = "rate_spike"
+	//ReasonRateDrop is used for a pattern that matched far less often than usual in a window
+	ReasonRateDrop Reason = "rate_drop"
+	//ReasonConfirmed is used for every line matching a pattern that was confirmed with MarkConfirmed
+	ReasonConfirmed Reason = "confirmed"
+	//ReasonAlertRule is used for a line matching a Rule with ActionAlert
+	ReasonAlertRule Reason = "alert_rule"
+	//ReasonRareTransition is used for a line whose pattern seldom follows the patterns matched before it
+	ReasonRareTransition Reason = "rare_transition"
+	//ReasonMissingSuccessor is used for a line whose pattern was not followed within Options.SequenceTimeout
+	//by a pattern that nearly always follows it, such as a session that was opened and never closed
+	ReasonMissingSuccessor Reason = "missing_successor"
+	//ReasonValueOutlier is used for a line whose number or duration in a wildcard slot is far outside
+	//the range the slot has learned, see Options.ReportOutliers
+	ReasonValueOutlier Reason = "value_outlier"
+	//ReasonNewValue is used for a line with a value never seen before in a wildcard slot that holds a
+	//small set of values, such as a new interface name or status code, see Options.ReportNewValues
+	ReasonNewValue Reason = "new_value"
+)
+
+//Anomaly describes a line that Pulse thinks is out of place
+type Anomaly struct {
+	//ID identifies the anomaly for MarkBenign and MarkConfirmed.  IDs count up from 1 for each
+	//detector and carry on across Save and Load.
+	ID int64
+	//Line is the raw input line, empty for anomalies about the rate of a pattern
+	Line string
+	//Lines is every line of the event when Options.Multiline joined continuation lines onto Line,
+	//starting with Line.  Nil for an event of a single line.
+	Lines []string
+	//Header is the metadata stripped from the front of the line when Options.StripHeaders is set
+	Header Header
+	//Time is when the line arrived
+	Time time.Time
+	//Seq is the position of the line in the input, starting at 1
+	Seq int64
+	//Source is the name of the stream the line was read from, Record.Source or Options.Source
+	Source string
+	//Labels are the labels of the Record the line came in
+	Labels map[string]string
+	//Pattern is the template of the nearest pattern, or empty if no pattern was close
+	Pattern string
+	//PatternID identifies the pattern in Pattern.  It stays the same for as long as the pattern is kept,
+	//including across Save and Load, so it can be used as a key.  0 when Pattern is empty.
+	PatternID int64
+	//Score is how similar the line was to the closest thing Pulse has learned, from 0 to 1.
+	//It is the token overlap with Pattern when that is set, otherwise the similarity to the closest unmatched line.
+	Score float64
+	//Reason is why the line was reported
+	Reason Reason
+	//Slot is the position of the wildcard in Pattern the anomaly is about, counting from 1,
+	//or 0 when the anomaly is not about a single slot
+	Slot int
+	//Value is the value the line had in Slot
+	Value string
+	//Expected describes what the slot has learned to expect, such as <INT>
+	Expected string
+	//Rule is the name of the alert Rule the line matches, whatever the reason it was reported for
+	Rule string
+	//Chain is the ids of the patterns matched before the line, oldest first, for anomalies about the order
+	//of patterns.  For ReasonMissingSuccessor it ends with the pattern of the line.
+	Chain []int64
+}
+
+//Handler is called with every anomaly a Detector reports
+type Handler func(Anomaly)
+
+//wildcardPlaceholder is how a variable token is shown in a template
+const wildcardPlaceholder = "<*>"
+
+//renders a pattern as a human readable template
+func (p *pattern) template() string {
+	words := make([]string, len(p.tokens))
+	for i := range p.tokens {
+		if p.tokens[i].variable {
+			words[i] = p.tokens[i].kind.Placeholder()
+		} else {
+			words[i] = p.tokens[i].word
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,104 @@
+package pulse
+
+import (
+	"math"
+	"time"
+)
+
+//Clock tells a Detector what time it is, used for unmatched timeouts and rate decay
+type Clock interface {
+	Now() time.Time
+}
+
+//SystemClock is a Clock that reads the wall clock
+type SystemClock struct{}
+
+//Now returns the current wall clock time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+//TimeParser pulls the event time out of a line, returning false if the line has no timestamp
+type TimeParser func(line string) (time.Time, bool)
+
+//TimePrefixParser returns a TimeParser that reads a timestamp at the start of the line using
+//the first of the fixed width layouts that fits
+func TimePrefixParser(layouts ...string) TimeParser {
+	return func(line string) (time.Time, bool) {
+		for _, layout := range layouts {
+			if len(line) < len(layout) {
+				continue
+			}
+			if t, err := time.Parse(layout, line[:len(layout)]); err == nil {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+}
+
+//SyslogTime reads the "Jan _2 15:04:05" timestamp that starts syslog and kern.log lines
+var SyslogTime = TimePrefixParser(time.Stamp)
+
+//returns the time of a line.  When the line came in a Record with a Time, the options have a TimeParser,
+//or take the time from the header, the time comes from the line so timeouts and decay run in log time.
+//Lines without a timestamp keep the time of the line before.
+func (d *Detector) lineTime(w *lineWork) time.Time {
+	var t time.Time
+	var ok bool
+	switch {
+	case !w.at.IsZero():
+		t, ok = w.at, true
+	case d.opts.ParseTime != nil:
+		t, ok = d.opts.ParseTime(w.line)
+	case d.opts.HeaderTime:
+		t, ok = w.header.Timestamp, !w.header.Timestamp.IsZero()
+	default:
+		return d.opts.Clock.Now()
+	}
+	if !ok {
+		return d.logTime
+	}
+
+	//timestamps without a year are placed in the year of the last line,
+	//moving to the next year when they go back more than a month
+	if t.Year() == 0 {
+		year := d.logTime.Year()
+		if d.logTime.IsZero() {
+			year = d.opts.Clock.Now().Year()
+		}
+		t = t.AddDate(year, 0, 0)
+		if t.Before(d.logTime.AddDate(0, -1, 0)) {
+			t = t.AddDate(1, 0, 0)
+		}
+	}
+
+	if d.logTime.IsZero() {
+		//lines read before the first timestamp are dated with it
+		for i := range d.unmatched {
+			if d.unmatched[i].dateStored.IsZero() {
+				d.unmatched[i].dateStored = t
+			}
+		}
+	}
+	if t.After(d.logTime) {
+		d.logTime = t
+	}
+	return d.logTime
+}
+
+//decays the pattern creation rate, once per line or once per DecayInterval of clock time
+func (d *Detector) decayCreationRate(now time.Time) {
+	if d.opts.DecayInterval <= 0 {
+		d.patternCreationRate = d.patternCreationRate * d.opts.RateDecay
+		return
+	}
+
+	if d.lastDecay.IsZero() || now.Before(d.lastDecay) {
+		d.lastDecay = now
+		return
+	}
+	intervals := float64(now.Sub(d.lastDecay)) / float64(d.opts.DecayInterval)
+	d.patternCreationRate = d.patternCreationRate * math.Pow(d.opts.RateDecay, intervals)
+	d.lastDecay = now
+}
@@ -0,0 +1,281 @@
+package pulse
+
+import "sort"
+
+//Consolidate merges patterns that align with each other and share at least Options.MergeSimilarity of
+//their fixed words, such as the same template with one extra wildcard.  The merged pattern keeps the id
+//of the pattern that has matched the most lines, and the counts and wildcard values of both.
+//It returns how many patterns were merged away, in the models of every source or label.
+func (d *Detector) Consolidate() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	merged := d.consolidate()
+	for _, c := range d.childList() {
+		merged += c.Consolidate()
+	}
+	return merged
+}
+
+func (d *Detector) consolidate() int {
+	//the most common patterns absorb the others
+	order := make([]*pattern, len(d.patterns))
+	copy(order, d.patterns)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].numMatches > order[j].numMatches })
+
+	merged := 0
+	removed := make(map[*pattern]bool)
+	for _, a := range order {
+		if removed[a] {
+			continue
+		}
+		for {
+			b, m := d.mergeCandidate(a, removed)
+			if m == nil {
+				break
+			}
+			d.mergeInto(a, b, m)
+			removed[a] = true
+			removed[b] = true
+			a = m
+			merged++
+		}
+	}
+
+	if merged > 0 {
+		d.generation++
+	}
+	return merged
+}
+
+//returns the first pattern sharing a word with a that can be merged with it, and the merged pattern
+func (d *Detector) mergeCandidate(a *pattern, removed map[*pattern]bool) (*pattern, *pattern) {
+	seen := make(map[int64]bool)
+	var candidates []*pattern
+	for i := range a.tokens {
+		if a.tokens[i].variable {
+			continue
+		}
+		for _, b := range d.patternsFromToken(a.tokens[i].word) {
+			if b != a && !removed[b] && !seen[b.id] {
+				seen[b.id] = true
+				candidates = append(candidates, b)
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].id < candidates[j].id })
+
+	for _, b := range candidates {
+		if !d.mayMerge(a, b) {
+			continue
+		}
+		if m := d.mergePatterns(a, b); m != nil {
+			return b, m
+		}
+	}
+	return nil, nil
+}
+
+//a quick check that two patterns share enough words to pass mergePatterns, which is much slower.
+//patterns with different feedback are never merged, nor are confirmed patterns.
+//every fixed word of the merged pattern is a word of a found in b and of b found in a, and the
+//alignment may pair a word with more than one other, so at most this many words are kept.
+func (d *Detector) mayMerge(a, b *pattern) bool {
+	if a.pin != b.pin || a.pin == pinAlert {
+		return false
+	}
+	shared := a.wordsIn(b) + b.wordsIn(a)
+	fixed := max(a.fixedWords(), b.fixedWords())
+	return fixed > 0 && float64(shared)/float64(fixed) >= d.opts.MergeSimilarity
+}
+
+//returns how many fixed words of the pattern are also fixed words of other
+func (p *pattern) wordsIn(other *pattern) int {
+	words := make(map[string]bool)
+	for i := range other.tokens {
+		if !other.tokens[i].variable {
+			words[other.tokens[i].word] = true
+		}
+	}
+	n := 0
+	for i := range p.tokens {
+		if !p.tokens[i].variable && words[p.tokens[i].word] {
+			n++
+		}
+	}
+	return n
+}
+
+//aligns two patterns the same way a line is aligned with a pattern and returns the merged pattern,
+//or nil if they are not similar enough
+func (d *Detector) mergePatterns(a, b *pattern) *pattern {
+	short, long := a, b
+	if len(long.tokens) < len(short.tokens) {
+		short, long = b, a
+	}
+	matrix, vertices := buildMatrix(short.words(), long.words())
+	foundPattern, vertices := analyzeMatrix(matrix, vertices, d.opts.VertexPreference)
+	if !foundPattern {
+		return nil
+	}
+
+	var m pattern
+	lastPoint := vertex{-1, -1, 0}
+	for i := range vertices {
+		var skippedBeginning = i == 0 && vertices[i].x != 0 && vertices[i].y != 0
+		var vertex = vertices[i]
+		var distance = (vertex.x - lastPoint.x) + (vertex.y - lastPoint.y)
+		if distance > d.opts.VertexDistance || skippedBeginning {
+			m.tokens = append(m.tokens, d.mergeSkipped(short, between(short.tokens, lastPoint.x, vertex.x), long, between(long.tokens, lastPoint.y, vertex.y)))
+		}
+		m.tokens = append(m.tokens, d.mergeToken(short.tokens[vertex.x], long.tokens[vertex.y]))
+		lastPoint = vertex
+	}
+	if lastPoint.x+1 < len(short.tokens) || lastPoint.y+1 < len(long.tokens) {
+		m.tokens = append(m.tokens, d.mergeSkipped(short, between(short.tokens, lastPoint.x, len(short.tokens)), long, between(long.tokens, lastPoint.y, len(long.tokens))))
+	}
+
+	fixed := max(a.fixedWords(), b.fixedWords())
+	if fixed == 0 || float64(m.fixedWords())/float64(fixed) < d.opts.MergeSimilarity {
+		return nil
+	}
+
+	keep := a
+	if b.numMatches > a.numMatches {
+		keep = b
+	}
+	m.id = keep.id
+	m.pin = a.pin
+	m.numMatches = a.numMatches + b.numMatches
+	m.lastSeen = a.lastSeen
+	if b.lastSeen > m.lastSeen {
+		m.lastSeen = b.lastSeen
+	}
+	m.firstSeen, m.lastMatched = a.firstSeen, a.lastMatched
+	if b.firstSeen.Before(m.firstSeen) || m.firstSeen.IsZero() {
+		m.firstSeen = b.firstSeen
+	}
+	if b.lastMatched.After(m.lastMatched) {
+		m.lastMatched = b.lastMatched
+	}
+	m.rate = patternRate{
+		windowStartMatches: a.rate.windowStartMatches + b.rate.windowStartMatches,
+		mean:               a.rate.mean + b.rate.mean,
+		windows:            a.rate.windows,
+	}
+	if b.rate.windows > m.rate.windows {
+		m.rate.windows = b.rate.windows
+	}
+	return &m
+}
+
+//merges two tokens at a vertex.  They have the same word, so either both are fixed or both are wildcards.
+func (d *Detector) mergeToken(s, l token) token {
+	if !s.variable {
+		return token{word: s.word, required: true}
+	}
+	t := token{word: "!WILDCARD!", variable: true, kind: mergeKind(s.kind, l.kind), samples: s.samples + l.samples, stats: mergeStats(s.stats, l.stats), stable: s.stable}
+	t.variations = d.mergeVariations(s.variations, l.variations)
+	t.required = s.required || l.required || len(t.variations) > 1
+	if l.stable < t.stable {
+		t.stable = l.stable
+	}
+	return t
+}
+
+//returns a wildcard for tokens skipped between two vertices.  Skipped wildcards bring their values,
+//skipped fixed words become a value the same way they do when a pattern is first found.
+func (d *Detector) mergeSkipped(short *pattern, shortTokens []token, long *pattern, longTokens []token) token {
+	t := token{word: "!WILDCARD!", variable: true}
+	for _, side := range []struct {
+		p      *pattern
+		tokens []token
+	}{{short, shortTokens}, {long, longTokens}} {
+		text := ""
+		for _, st := range side.tokens {
+			if !st.variable {
+				text += st.word
+				continue
+			}
+			t.kind = mergeKind(t.kind, st.kind)
+			t.samples += st.samples
+			t.stats = mergeStats(t.stats, st.stats)
+			t.variations = d.mergeVariations(t.variations, st.variations)
+		}
+		if text != "" {
+			t.addVariations(text, side.p.numMatches, d.opts.MaxVariations)
+			t.learnKind(text)
+			t.learnNumber(text)
+		}
+	}
+	t.required = len(t.variations) > 1
+	return t
+}
+
+//returns the values of two slots counted together, keeping to Options.MaxVariations
+func (d *Detector) mergeVariations(a, b []variation) []variation {
+	t := token{variations: append([]variation{}, a...)}
+	positions := make(map[string]int, len(a))
+	for i := range a {
+		positions[a[i].text] = i
+	}
+	for _, v := range b {
+		if i, ok := positions[v.text]; ok {
+			t.variations[i].numMatches += v.numMatches
+			continue
+		}
+		t.addVariations(v.text, v.numMatches, d.opts.MaxVariations)
+		positions[t.variations[len(t.variations)-1].text] = len(t.variations) - 1
+	}
+	return t.variations
+}
+
+//returns the tokens strictly between two positions, vertices may share a row or column
+//so the range can be empty
+func between(tokens []token, from, to int) []token {
+	if to <= from+1 {
+		return nil
+	}
+	return tokens[from+1 : to]
+}
+
+//returns the words of the pattern, wildcards included
+func (p *pattern) words() []string {
+	words := make([]string, len(p.tokens))
+	for i := range p.tokens {
+		words[i] = p.tokens[i].word
+	}
+	return words
+}
+
+//returns how many tokens of the pattern are fixed words
+func (p *pattern) fixedWords() int {
+	n := 0
+	for i := range p.tokens {
+		if !p.tokens[i].variable {
+			n++
+		}
+	}
+	return n
+}
+
+//replaces a and b in the model with m, which takes the place of a
+func (d *Detector) mergeInto(a, b, m *pattern) {
+	d.unindexPattern(a)
+	d.unindexPattern(b)
+	for i := range d.patterns {
+		if d.patterns[i] == a {
+			d.patterns[i] = m
+		}
+	}
+	for i := range d.patterns {
+		if d.patterns[i] == b {
+			d.patterns = append(d.patterns[:i], d.patterns[i+1:]...)
+			break
+		}
+	}
+	d.indexPattern(m)
+	if d.sequence != nil {
+		d.sequence.remap(a.id, m.id)
+		d.sequence.remap(b.id, m.id)
+	}
+}
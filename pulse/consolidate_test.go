@@ -0,0 +1,61 @@
+package pulse_test
+
+import (
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestConsolidate(t *testing.T) {
+	lines := kernLog(t, 500)
+	d := New(kernOptions())
+	for _, line := range lines {
+		d.Analyze(line)
+	}
+
+	before := d.Memory().Patterns
+	merged := d.Consolidate()
+	after := d.Memory().Patterns
+	if merged == 0 || after != before-merged {
+		t.Errorf("Pattern count does not match")
+		t.Logf("Expected: %d merged from %d", before-after, before)
+		t.Logf("Actual: %d merged", merged)
+	}
+	if again := d.Consolidate(); again != 0 {
+		t.Errorf("Consolidating twice should not merge again")
+		t.Logf("Actual: %d merged", again)
+	}
+
+	//the index must lead only to patterns that are still kept, and the merged patterns should
+	//match at least as much as the patterns they replaced
+	plain := New(kernOptions())
+	for _, line := range append(lines, lines...) {
+		plain.Analyze(line)
+	}
+	for _, line := range lines {
+		d.Analyze(line)
+	}
+	if m := d.Memory(); m.Patterns > plain.Memory().Patterns {
+		t.Errorf("Merged patterns matched fewer lines")
+		t.Logf("Expected: at most %d patterns", plain.Memory().Patterns)
+		t.Logf("Actual: %d patterns", m.Patterns)
+	}
+}
+
+func TestConsolidateSequence(t *testing.T) {
+	opts := kernOptions()
+	opts.SequenceOrder = 1
+	d := New(opts)
+	for _, line := range kernLog(t, 500) {
+		d.Analyze(line)
+	}
+
+	//the order learned for merged patterns is folded onto the pattern that replaces them
+	before := d.Memory().SequenceEntries
+	merged := d.Consolidate()
+	if after := d.Memory().SequenceEntries; merged == 0 || after >= before {
+		t.Errorf("Sequence counts were kept apart for merged patterns")
+		t.Logf("Expected: fewer than %d entries", before)
+		t.Logf("Actual: %d entries, %d merged", after, merged)
+	}
+}
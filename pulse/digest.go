@@ -0,0 +1,123 @@
+package pulse
+
+import (
+	"math"
+	"sort"
+)
+
+//how finely a digest keeps the distribution, it holds at most a few times this many centroids
+const digestCompression = 100
+
+//how many values are buffered before they are merged into the centroids
+const digestBuffer = 500
+
+//a t-digest, which estimates the quantiles of a stream of values in little memory.  Centroids near
+//the tails hold few values, so extreme quantiles stay accurate.
+type digest struct {
+	centroids []centroid
+	buffer    []centroid
+	total     float64
+	min       float64
+	max       float64
+}
+
+//the mean of weight values that are next to each other in the distribution
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+func (g *digest) add(x float64) {
+	if g.total == 0 || x < g.min {
+		g.min = x
+	}
+	if g.total == 0 || x > g.max {
+		g.max = x
+	}
+	g.total++
+	g.buffer = append(g.buffer, centroid{x, 1})
+	if len(g.buffer) >= digestBuffer {
+		g.compress()
+	}
+}
+
+//adds the values of another digest
+func (g *digest) merge(o *digest) {
+	if o.total == 0 {
+		return
+	}
+	if g.total == 0 || o.min < g.min {
+		g.min = o.min
+	}
+	if g.total == 0 || o.max > g.max {
+		g.max = o.max
+	}
+	g.total += o.total
+	g.buffer = append(g.buffer, o.centroids...)
+	g.buffer = append(g.buffer, o.buffer...)
+	g.compress()
+}
+
+func (g *digest) compress() {
+	if len(g.buffer) > 0 {
+		g.centroids = g.merged()
+		g.buffer = nil
+	}
+}
+
+//returns the centroids with the buffered values merged in, without changing the digest
+func (g *digest) merged() []centroid {
+	all := make([]centroid, 0, len(g.centroids)+len(g.buffer))
+	all = append(append(all, g.centroids...), g.buffer...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	var out []centroid
+	//the weight of the centroids before the last one in out
+	var before float64
+	for _, c := range all {
+		if n := len(out); n > 0 {
+			last := &out[n-1]
+			q := (before + (last.weight+c.weight)/2) / g.total
+			if last.weight+c.weight <= 4*g.total*q*(1-q)/digestCompression {
+				last.mean += (c.mean - last.mean) * c.weight / (last.weight + c.weight)
+				last.weight += c.weight
+				continue
+			}
+			before += last.weight
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+//returns the estimated value below which the fraction q of the values lie
+func (g *digest) quantile(q float64) float64 {
+	g.compress()
+	if len(g.centroids) == 0 {
+		return 0
+	}
+	if len(g.centroids) == 1 {
+		return g.centroids[0].mean
+	}
+	//values are interpolated between the centers of the centroids, and the smallest and largest value
+	target := q * g.total
+	var cumulative float64
+	for i, c := range g.centroids {
+		center := cumulative + c.weight/2
+		if target < center {
+			if i == 0 {
+				return g.min + (c.mean-g.min)*target/center
+			}
+			prev := g.centroids[i-1]
+			prevCenter := cumulative - prev.weight/2
+			return prev.mean + (c.mean-prev.mean)*(target-prevCenter)/(center-prevCenter)
+		}
+		cumulative += c.weight
+	}
+	last := g.centroids[len(g.centroids)-1]
+	lastCenter := g.total - last.weight/2
+	if g.total == lastCenter {
+		return g.max
+	}
+	return math.Min(g.max, last.mean+(g.max-last.mean)*(target-lastCenter)/(g.total-lastCenter))
+}
@@ -0,0 +1,195 @@
+package pulse
+
+import (
+	"errors"
+	"fmt"
+)
+
+//ErrUnknownAnomaly is returned for feedback about an anomaly the detector did not report,
+//or no longer remembers.  See Options.History.
+var ErrUnknownAnomaly = errors.New("pulse: unknown anomaly")
+
+//feedback an operator gave about a pattern
+type pin int8
+
+const (
+	//the pattern is reported as usual
+	pinNone pin = iota
+	//the pattern is known to be fine, it is never reported as rare, for its values or for its rate
+	pinBenign
+	//every line matching the pattern is reported
+	pinAlert
+)
+
+//MarkBenign tells the detector that a reported anomaly is fine.  When the anomaly was about a line
+//no pattern matched, the line is learned as a pattern together with the unmatched lines that are
+//similar to it, so they are no longer reported.  The pattern is then never reported as rare, for its
+//values or for its rate.  The feedback is kept when the model is saved.
+func (d *Detector) MarkBenign(id int64) error {
+	return d.mark(id, pinBenign)
+}
+
+//MarkConfirmed tells the detector that a reported anomaly is a real problem.  Its pattern, learned from
+//the line as with MarkBenign when no pattern matched it, is pinned so that every line matching it is
+//reported with ReasonConfirmed, and it is never forgotten or merged.  The feedback is kept when the model is saved.
+func (d *Detector) MarkConfirmed(id int64) error {
+	return d.mark(id, pinAlert)
+}
+
+func (d *Detector) mark(id int64, to pin) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	a, ok := d.recall(id)
+	if !ok {
+		//anomalies of a model kept per source or label are remembered by its child
+		for _, c := range d.childList() {
+			if err := c.mark(id, to); err != ErrUnknownAnomaly {
+				return err
+			}
+		}
+		return ErrUnknownAnomaly
+	}
+
+	p := d.byID[a.PatternID]
+	if !a.Reason.matched() || p == nil {
+		if a.Line == "" {
+			return fmt.Errorf("pulse.mark: pattern %d of anomaly %d is no longer kept", a.PatternID, id)
+		}
+		p = d.promote(a)
+	}
+	p.pin = to
+	return nil
+}
+
+//returns true if anomalies for the reason are about a line that matched Anomaly.PatternID,
+//instead of a line that only came close to it
+func (r Reason) matched() bool {
+	switch r {
+	case ReasonRarePattern, ReasonTypeMismatch, ReasonRateSpike, ReasonRateDrop, ReasonConfirmed, ReasonRareTransition, ReasonMissingSuccessor, ReasonValueOutlier, ReasonNewValue:
+		return true
+	}
+	return false
+}
+
+//gives the anomaly an id, remembers it for feedback and hands it to the handler, unless an ignore rule
+//matches its line.  A line matching an alert rule is reported as usual, with the name of the rule.
+func (d *Detector) send(a Anomaly) {
+	if i := d.matchRule(a); i >= 0 {
+		if d.opts.Rules[i].Action == ActionIgnore {
+			d.ruleHits[i]++
+			return
+		}
+		a.Rule = d.opts.Rules[i].Name
+	}
+	if a.Line != "" && a.Seq == d.seq {
+		d.lineSent = d.seq
+	}
+	a.ID = d.newAnomalyID()
+	d.remember(a)
+	if d.handler != nil {
+		d.handler(a)
+	}
+}
+
+//keeps the last Options.History anomalies in a ring
+func (d *Detector) remember(a Anomaly) {
+	if len(d.history) < d.opts.History {
+		d.history = append(d.history, a)
+		return
+	}
+	d.history[d.historyNext] = a
+	d.historyNext = (d.historyNext + 1) % len(d.history)
+}
+
+func (d *Detector) recall(id int64) (Anomaly, bool) {
+	for i := range d.history {
+		if d.history[i].ID == id {
+			return d.history[i], true
+		}
+	}
+	return Anomaly{}, false
+}
+
+//returns the remembered anomalies from the oldest to the newest
+func (d *Detector) recalled() []Anomaly {
+	return append(append([]Anomaly{}, d.history[d.historyNext:]...), d.history[:d.historyNext]...)
+}
+
+//learns the line of an anomaly as a pattern.  The line is matched against the patterns again, as one may
+//have been learned since it was reported, otherwise a pattern is searched for between it and the closest
+//unmatched line, and failing that the line becomes a pattern as it is.  The unmatched lines matching the
+//pattern are then matched with it.
+func (d *Detector) promote(a Anomaly) *pattern {
+	if i := d.unmatchedSeq(a.Seq); i >= 0 {
+		d.forgetCandidate(d.unmatched[i])
+		d.unmatched = append(d.unmatched[:i], d.unmatched[i+1:]...)
+	}
+
+	w := d.prepare([]Record{{Line: a.Line, Source: a.Source, Labels: a.Labels}})
+	d.read(w)
+	var p *pattern
+	if w.aligned {
+		p = w.candidate
+		d.matchPattern(p, w.alignedTokens)
+	} else if index, score := d.closestIndex(w); index >= 0 && score >= d.opts.SimilarityThreshold {
+		var unmatchedTokens = d.opts.Tokenizer.Tokenize(d.unmatched[index].body)
+		var found bool
+		if len(w.tokens) < len(unmatchedTokens) {
+			found = d.findPattern(w.tokens, unmatchedTokens)
+		} else {
+			found = d.findPattern(unmatchedTokens, w.tokens)
+		}
+		if found {
+			p = d.patterns[len(d.patterns)-1]
+			d.learnedFrom(d.unmatched[index])
+			d.forgetCandidate(d.unmatched[index])
+			d.unmatched = append(d.unmatched[:index], d.unmatched[index+1:]...)
+		}
+	}
+	if p == nil {
+		p = d.learnLine(w.tokens)
+	}
+
+	d.matchUnmatched(p)
+	return p
+}
+
+//learns a pattern with every token of a line as a fixed word
+func (d *Detector) learnLine(tokens []string) *pattern {
+	p := &pattern{numMatches: 1, lastSeen: d.seq, firstSeen: d.lineAt, lastMatched: d.lineAt}
+	for _, word := range tokens {
+		p.tokens = append(p.tokens, token{word: word, required: true})
+	}
+	d.patterns = append(d.patterns, p)
+	d.indexPattern(p)
+	d.generation++
+	d.evictPatterns(p)
+	return p
+}
+
+//matches the unmatched lines whose likeliest pattern is p with it, and removes them from the unmatched list
+func (d *Detector) matchUnmatched(p *pattern) {
+	for i := 0; i < len(d.unmatched); {
+		tokens := d.opts.Tokenizer.Tokenize(d.unmatched[i].body)
+		candidate, inCommon := d.likeliestPattern(tokens)
+		if candidate == p && float64(inCommon)/float64(len(tokens)) >= d.opts.TokenMatchRatio {
+			if aligned, newTokens := d.alignPattern(p, tokens); aligned {
+				d.matchPattern(p, newTokens)
+				d.forgetCandidate(d.unmatched[i])
+				d.unmatched = append(d.unmatched[:i], d.unmatched[i+1:]...)
+				continue
+			}
+		}
+		i++
+	}
+}
+
+//returns the index of the unmatched line read at seq, or -1
+func (d *Detector) unmatchedSeq(seq int64) int {
+	for i := range d.unmatched {
+		if d.unmatched[i].seq == seq {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,69 @@
+package pulse_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestMarkBenign(t *testing.T) {
+	var anomalies []Anomaly
+	d := trainLogins(DefaultOptions(), &anomalies)
+
+	d.Analyze("disk sda1 is full on host h1")
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly, got %d", len(anomalies))
+	}
+	if err := d.MarkBenign(anomalies[0].ID); err != nil {
+		t.Fatalf("Could not mark anomaly %d as benign. %s", anomalies[0].ID, err)
+	}
+
+	d.Analyze("disk sda1 is full on host h1")
+	if len(anomalies) != 1 {
+		t.Errorf("A line marked as benign was reported again")
+		t.Logf("Actual: %v", anomalies[1:])
+	}
+	if err := d.MarkBenign(42); err != ErrUnknownAnomaly {
+		t.Errorf("Feedback about an unknown anomaly should fail")
+		t.Logf("Expected: %s", ErrUnknownAnomaly)
+		t.Logf("Actual: %v", err)
+	}
+}
+
+func TestMarkConfirmedSurvivesLoad(t *testing.T) {
+	var anomalies []Anomaly
+	d := trainLogins(DefaultOptions(), &anomalies)
+
+	d.Analyze("kernel panic not syncing")
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly, got %d", len(anomalies))
+	}
+	if err := d.MarkConfirmed(anomalies[0].ID); err != nil {
+		t.Fatalf("Could not confirm anomaly %d. %s", anomalies[0].ID, err)
+	}
+
+	var saved bytes.Buffer
+	if err := d.Save(&saved); err != nil {
+		t.Fatalf("Could not save model. %s", err)
+	}
+	loaded, err := Load(&saved)
+	if err != nil {
+		t.Fatalf("Could not load model. %s", err)
+	}
+	loaded.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+	loaded.Analyze("kernel panic not syncing")
+
+	if len(anomalies) != 2 || anomalies[1].Reason != ReasonConfirmed {
+		t.Fatalf("A confirmed line was not reported after Load")
+	}
+	if anomalies[1].ID <= anomalies[0].ID {
+		t.Errorf("Anomaly ids started over after Load")
+		t.Logf("Expected: more than %d", anomalies[0].ID)
+		t.Logf("Actual: %d", anomalies[1].ID)
+	}
+	//the first anomaly is still remembered by the loaded model
+	if err := loaded.MarkBenign(anomalies[0].ID); err != nil {
+		t.Errorf("Could not give feedback about an anomaly reported before Save. %s", err)
+	}
+}
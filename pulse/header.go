@@ -0,0 +1,124 @@
+package pulse
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Header formats recognized by ParseHeader
+const (
+	FormatRFC3164 = "rfc3164"
+	FormatRFC5424 = "rfc5424"
+	FormatISO8601 = "iso8601"
+	FormatApache  = "apache"
+	FormatKernel  = "kernel"
+)
+
+//Header is the metadata found in front of the message of a log line
+type Header struct {
+	//Format is the kind of header that was found, or empty if there was none
+	Format string
+	//Timestamp is the time in the header, zero if the header had none
+	Timestamp time.Time
+	//Priority is the syslog priority, -1 if the header had none
+	Priority int
+	//Host is the host name, or the client address for apache access logs
+	Host string
+	//App is the program that wrote the line
+	App string
+	//PID is the process id of the program that wrote the line
+	PID string
+	//Uptime is the kernel [uptime] stamp, zero if the line had none
+	Uptime time.Duration
+}
+
+var (
+	rfc5424Header = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\S+) \S+ (?:-|(?:\[(?:[^\]\\]|\\.)*\])+)(?: |$)`)
+	rfc3164Header = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d) (\S+) ([^\s:\[]+)(?:\[(\d+)\])?:(?: |$)`)
+	iso8601Header = regexp.MustCompile(`^(\d{4}-\d\d-\d\d[T ]\d\d:\d\d:\d\d(?:[.,]\d+)?(?:Z|[+-]\d\d:?\d\d)?)(?: |$)`)
+	apacheError   = regexp.MustCompile(`^\[([A-Z][a-z]{2} [A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d(?:\.\d+)? \d{4})\](?: |$)`)
+	apacheAccess  = regexp.MustCompile(`^(\S+) \S+ \S+ \[(\d\d/[A-Z][a-z]{2}/\d{4}:\d\d:\d\d:\d\d [+-]\d{4})\](?: |$)`)
+	kernelUptime  = regexp.MustCompile(`^\[\s*(\d+)\.(\d+)\](?: |$)`)
+)
+
+//layouts tried in order for ISO-8601 timestamps
+var isoLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02 15:04:05",
+}
+
+//ParseHeader splits a line into the header and the message body.  It recognizes RFC3164 and
+//RFC5424 syslog, ISO-8601 timestamps, apache error and access log times and kernel [uptime] stamps.
+//A kernel stamp may follow any of the others.  If no header is found the whole line is the body.
+func ParseHeader(line string) (Header, string) {
+	h := Header{Priority: -1}
+	body := line
+
+	if m := rfc5424Header.FindStringSubmatch(body); m != nil {
+		h.Format = FormatRFC5424
+		h.Priority, _ = strconv.Atoi(m[1])
+		h.Timestamp, _ = time.Parse(time.RFC3339Nano, m[2])
+		h.Host = nilValue(m[3])
+		h.App = nilValue(m[4])
+		h.PID = nilValue(m[5])
+		body = body[len(m[0]):]
+	} else if m := rfc3164Header.FindStringSubmatch(body); m != nil {
+		h.Format = FormatRFC3164
+		if m[1] != "" {
+			h.Priority, _ = strconv.Atoi(m[1])
+		}
+		h.Timestamp, _ = time.Parse(time.Stamp, m[2])
+		h.Host = m[3]
+		h.App = m[4]
+		h.PID = m[5]
+		body = body[len(m[0]):]
+	} else if m := iso8601Header.FindStringSubmatch(body); m != nil {
+		h.Format = FormatISO8601
+		value := strings.Replace(m[1], ",", ".", 1)
+		for _, layout := range isoLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				h.Timestamp = t
+				break
+			}
+		}
+		body = body[len(m[0]):]
+	} else if m := apacheError.FindStringSubmatch(body); m != nil {
+		h.Format = FormatApache
+		h.Timestamp, _ = time.Parse("Mon Jan _2 15:04:05 2006", m[1])
+		if h.Timestamp.IsZero() {
+			h.Timestamp, _ = time.Parse("Mon Jan _2 15:04:05.000000 2006", m[1])
+		}
+		body = body[len(m[0]):]
+	} else if m := apacheAccess.FindStringSubmatch(body); m != nil {
+		h.Format = FormatApache
+		h.Host = m[1]
+		h.Timestamp, _ = time.Parse("02/Jan/2006:15:04:05 -0700", m[2])
+		body = body[len(m[0]):]
+	}
+
+	if m := kernelUptime.FindStringSubmatch(body); m != nil {
+		if h.Format == "" {
+			h.Format = FormatKernel
+		}
+		seconds, _ := strconv.ParseInt(m[1], 10, 64)
+		fraction, _ := strconv.ParseFloat("0."+m[2], 64)
+		h.Uptime = time.Duration(seconds)*time.Second + time.Duration(fraction*float64(time.Second))
+		body = body[len(m[0]):]
+	}
+
+	return h, body
+}
+
+//RFC5424 uses a dash for values that are not present
+func nilValue(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,80 @@
+package pulse_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		line   string
+		format string
+		host   string
+		app    string
+		body   string
+		uptime time.Duration
+	}{
+		{"Jan 12 06:25:01 myhost kernel: [12345.678] eth0: link up", FormatRFC3164, "myhost", "kernel", "eth0: link up", 12345678 * time.Millisecond},
+		{"<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed", FormatRFC3164, "mymachine", "su", "'su root' failed", 0},
+		{"<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\"] An application event", FormatRFC5424, "mymachine.example.com", "evntslog", "An application event", 0},
+		{"2016-01-23T10:04:05.123+01:00 worker started", FormatISO8601, "", "", "worker started", 0},
+		{"[Wed Oct 11 14:32:52 2000] [error] [client 127.0.0.1] client denied", FormatApache, "", "", "[error] [client 127.0.0.1] client denied", 0},
+		{"127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] \"GET /apache_pb.gif HTTP/1.0\" 200 2326", FormatApache, "127.0.0.1", "", "\"GET /apache_pb.gif HTTP/1.0\" 200 2326", 0},
+		{"[    0.000000] Initializing cgroup subsys cpuset", FormatKernel, "", "", "Initializing cgroup subsys cpuset", 0},
+		{"no header here", "", "", "", "no header here", 0},
+	}
+
+	for _, test := range tests {
+		h, body := ParseHeader(test.line)
+		if h.Format != test.format {
+			t.Errorf("Format does not match for %q", test.line)
+			t.Logf("Expected: %s", test.format)
+			t.Logf("Actual: %s", h.Format)
+		}
+		if h.Host != test.host {
+			t.Errorf("Host does not match for %q", test.line)
+		}
+		if h.App != test.app {
+			t.Errorf("App does not match for %q", test.line)
+		}
+		if body != test.body {
+			t.Errorf("Body does not match for %q", test.line)
+			t.Logf("Expected: %s", test.body)
+			t.Logf("Actual: %s", body)
+		}
+		if h.Uptime != test.uptime {
+			t.Errorf("Uptime does not match for %q", test.line)
+		}
+		if test.format != "" && test.format != FormatKernel && h.Timestamp.IsZero() {
+			t.Errorf("Timestamp missing for %q", test.line)
+		}
+	}
+}
+
+func TestStripHeaders(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.StripHeaders = true
+	opts.HeaderTime = true
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	for i := 0; i < 40; i++ {
+		d.Analyze("Jan 12 06:25:01 myhost sshd[" + strconv.Itoa(1000+i) + "]: session opened for user root")
+	}
+	d.Analyze("Jan 12 06:25:02 myhost kernel: [12.5] eth0: link down")
+
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly, got %d", len(anomalies))
+	}
+	a := anomalies[0]
+	if a.Header.App != "kernel" || a.Header.Host != "myhost" {
+		t.Errorf("Header was not attached to the anomaly")
+	}
+	if a.Time.Second() != 2 {
+		t.Errorf("Time should come from the header")
+	}
+}
@@ -0,0 +1,101 @@
+package pulse
+
+import "math"
+
+//the inverted index maps every word that is fixed in a pattern to the ids of the patterns that contain it.
+//Words are kept whole, so unrelated words never share an entry.
+type tokenIndex map[string]map[int64]bool
+
+//adds every fixed word of the pattern to the index, and gives the pattern an id if it has none
+func (d *Detector) indexPattern(p *pattern) {
+	if p.id == 0 {
+		d.nextID++
+		p.id = d.nextID
+	}
+	d.byID[p.id] = p
+	for i := range p.tokens {
+		if !p.tokens[i].variable {
+			d.indexWord(p.tokens[i].word, p)
+		}
+	}
+}
+
+func (d *Detector) indexWord(word string, p *pattern) {
+	ids := d.index[word]
+	if ids == nil {
+		ids = make(map[int64]bool)
+		d.index[word] = ids
+	}
+	ids[p.id] = true
+}
+
+//removes the pattern from the index, used when it is forgotten
+func (d *Detector) unindexPattern(p *pattern) {
+	for i := range p.tokens {
+		if !p.tokens[i].variable {
+			d.removeFromIndex(p.tokens[i].word, p.id)
+		}
+	}
+	delete(d.byID, p.id)
+}
+
+//removes a word of the pattern from the index, unless the pattern still has it in another token
+func (d *Detector) unindexWord(word string, p *pattern) {
+	for i := range p.tokens {
+		if !p.tokens[i].variable && p.tokens[i].word == word {
+			return
+		}
+	}
+	d.removeFromIndex(word, p.id)
+}
+
+func (d *Detector) removeFromIndex(word string, id int64) {
+	ids := d.index[word]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(d.index, word)
+	}
+}
+
+//returns all patterns that a particular word is part of, using the index
+func (d *Detector) patternsFromToken(word string) []*pattern {
+	ids := d.index[word]
+	keys := make([]*pattern, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, d.byID[id])
+	}
+	return keys
+}
+
+//returns how much a shared word says about a match.  Words found in few patterns weigh more than
+//words such as "the" or ":" that most patterns contain.
+func (d *Detector) idf(word string) float64 {
+	return math.Log(1 + float64(len(d.patterns))/float64(1+len(d.index[word])))
+}
+
+//returns the pattern sharing the most weight of words with the line and how many of the line's tokens
+//it shares.  Patterns are ranked by the idf of the shared words, ties go to the oldest pattern.
+func (d *Detector) likeliestPattern(lineTokens []string) (*pattern, int) {
+	var tokenMatches = make(map[*pattern]int)
+	var weights = make(map[*pattern]float64)
+	for i := range lineTokens {
+		var candidates = d.patternsFromToken(lineTokens[i])
+		if len(candidates) == 0 {
+			continue
+		}
+		var weight = d.idf(lineTokens[i])
+		for j := range candidates {
+			var p = candidates[j]
+			tokenMatches[p]++
+			weights[p] += weight
+		}
+	}
+
+	var best *pattern
+	for p := range weights {
+		if best == nil || weights[p] > weights[best] || (weights[p] == weights[best] && p.id < best.id) {
+			best = p
+		}
+	}
+	return best, tokenMatches[best]
+}
@@ -0,0 +1,98 @@
+package pulse_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestAnagramsDoNotMatch(t *testing.T) {
+	var anomalies []Anomaly
+	d := New(DefaultOptions())
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	for i := 0; i < 40; i++ {
+		d.Analyze(fmt.Sprintf("user u%d logged in from host h%d", i, i))
+	}
+	//every word is an anagram of a word in the pattern
+	d.Analyze("resu ni degglo morf tsoh")
+
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly, got %d", len(anomalies))
+	}
+	if anomalies[0].Pattern != "" || anomalies[0].PatternID != 0 {
+		t.Errorf("Anagrams should not lead to a pattern")
+		t.Logf("Actual: %d %s", anomalies[0].PatternID, anomalies[0].Pattern)
+	}
+}
+
+func TestPatternIDSurvivesLoad(t *testing.T) {
+	var anomalies []Anomaly
+	d := New(DefaultOptions())
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	for i := 0; i < 40; i++ {
+		d.Analyze(fmt.Sprintf("user u%d logged in from host h%d", i, i))
+	}
+	var saved bytes.Buffer
+	if err := d.Save(&saved); err != nil {
+		t.Fatalf("Could not save model. %s", err)
+	}
+	d.Analyze("user admin logged out")
+
+	loaded, err := Load(&saved)
+	if err != nil {
+		t.Fatalf("Could not load model. %s", err)
+	}
+	loaded.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+	loaded.Analyze("user admin logged out")
+
+	if len(anomalies) != 2 {
+		t.Fatalf("Expected 2 anomalies, got %d", len(anomalies))
+	}
+	if anomalies[0].PatternID == 0 || anomalies[0].PatternID != anomalies[1].PatternID {
+		t.Errorf("Pattern id changed across Save and Load")
+		t.Logf("Expected: %d", anomalies[0].PatternID)
+		t.Logf("Actual: %d", anomalies[1].PatternID)
+	}
+}
+
+func TestEvictedPatternsLeaveIndex(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.MaxPatterns = 3
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	formats := []string{
+		"user u%d logged in from host h%d",
+		"disk sd%d is %d percent full",
+		"link eth%d is down after %d retries",
+		"job j%d finished in %d seconds",
+		"cache c%d evicted %d entries",
+	}
+	//five kinds of lines keep evicting each other's patterns from a model that holds three
+	for i := 0; i < 40; i++ {
+		for _, format := range formats {
+			d.Analyze(fmt.Sprintf(format, i, i))
+		}
+	}
+	m := d.Memory()
+	if m.Patterns > opts.MaxPatterns || m.EvictedPatterns == 0 {
+		t.Errorf("Expected patterns to be evicted down to MaxPatterns")
+		t.Logf("Actual: %d patterns, %d evicted", m.Patterns, m.EvictedPatterns)
+	}
+
+	//the lines of evicted patterns are learned again instead of leading to a pattern that is gone
+	anomalies = nil
+	d.Analyze("user u99 logged in from host h99")
+	d.Analyze("user u98 logged in from host h98")
+	for _, a := range anomalies {
+		if a.PatternID != 0 && a.Pattern == "" {
+			t.Errorf("An anomaly refers to a forgotten pattern")
+			t.Logf("Actual: %v", a)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package pulse
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+//Kind is the type of value a wildcard slot has been seen to hold
+type Kind int
+
+//Kinds a wildcard slot can learn.  KindUnknown is used until the slot has seen a value.
+const (
+	KindUnknown Kind = iota
+	KindInt
+	KindFloat
+	KindHex
+	KindIPv4
+	KindIPv6
+	KindMAC
+	KindUUID
+	KindPath
+	KindDuration
+	KindText
+)
+
+var kindNames = map[Kind]string{
+	KindUnknown:  "unknown",
+	KindInt:      "int",
+	KindFloat:    "float",
+	KindHex:      "hex",
+	KindIPv4:     "ipv4",
+	KindIPv6:     "ipv6",
+	KindMAC:      "mac",
+	KindUUID:     "uuid",
+	KindPath:     "path",
+	KindDuration: "duration",
+	KindText:     "text",
+}
+
+var kindPlaceholders = map[Kind]string{
+	KindInt:      "<INT>",
+	KindFloat:    "<FLOAT>",
+	KindHex:      "<HEX>",
+	KindIPv4:     "<IP>",
+	KindIPv6:     "<IPV6>",
+	KindMAC:      "<MAC>",
+	KindUUID:     "<UUID>",
+	KindPath:     "<PATH>",
+	KindDuration: "<DURATION>",
+}
+
+//String returns the name of the kind
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+//MarshalJSON writes the kind as its name
+func (k Kind) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + k.String() + `"`), nil
+}
+
+//Placeholder returns how a slot of this kind is shown in a template, free text is shown as <*>
+func (k Kind) Placeholder() string {
+	if p, ok := kindPlaceholders[k]; ok {
+		return p
+	}
+	return wildcardPlaceholder
+}
+
+var (
+	intValue      = regexp.MustCompile(`^[+-]?\d+$`)
+	floatValue    = regexp.MustCompile(`^[+-]?(?:\d+\.\d*|\.\d+)(?:[eE][+-]?\d+)?$`)
+	hexValue      = regexp.MustCompile(`^(?:0[xX])?[0-9A-Fa-f]+$`)
+	macValue      = regexp.MustCompile(`^[0-9A-Fa-f]{2}(?:[:-][0-9A-Fa-f]{2}){5}$`)
+	uuidValue     = regexp.MustCompile(`^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`)
+	durationValue = regexp.MustCompile(`^(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h))+$`)
+	pathValue     = regexp.MustCompile(`^(?:~|\.{1,2})?/|^[A-Za-z]:\\`)
+)
+
+//inferKind returns the most specific kind that describes value
+func inferKind(value string) Kind {
+	switch {
+	case value == "":
+		return KindUnknown
+	case intValue.MatchString(value):
+		return KindInt
+	case floatValue.MatchString(value):
+		return KindFloat
+	case uuidValue.MatchString(value):
+		return KindUUID
+	case macValue.MatchString(value):
+		return KindMAC
+	case strings.Count(value, ".") == 3 && net.ParseIP(value) != nil:
+		return KindIPv4
+	case strings.Contains(value, ":") && net.ParseIP(value) != nil:
+		return KindIPv6
+	case hexValue.MatchString(value) && (strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") || strings.ContainsAny(value, "0123456789")):
+		//bare hex needs a digit so that words like "added" stay text
+		return KindHex
+	case durationValue.MatchString(value):
+		return KindDuration
+	case pathValue.MatchString(value):
+		return KindPath
+	}
+	return KindText
+}
+
+//mergeKind returns the narrowest kind that describes values of both kinds
+func mergeKind(a, b Kind) Kind {
+	switch {
+	case a == KindUnknown:
+		return b
+	case b == KindUnknown || a == b:
+		return a
+	case (a == KindInt && b == KindFloat) || (a == KindFloat && b == KindInt):
+		return KindFloat
+	case (a == KindInt && b == KindHex) || (a == KindHex && b == KindInt):
+		return KindHex
+	}
+	return KindText
+}
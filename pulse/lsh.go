@@ -0,0 +1,120 @@
+package pulse
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+//the MinHash signature of a line has minhashBands bands of minhashRows hashes each.  Two lines share
+//a band with probability 1-(1-s^rows)^bands for a Jaccard similarity s of their tokens, so lines that
+//could pass SimilarityThreshold nearly always share one while unrelated lines seldom do.
+const (
+	minhashBands = 16
+	minhashRows  = 2
+)
+
+//the locality sensitive index of unmatched lines, one map per band from the band's hash to the slots
+//of the lines with that band
+type lshIndex [minhashBands]map[uint64]map[int64]bool
+
+func newLSHIndex() *lshIndex {
+	var index lshIndex
+	for i := range index {
+		index[i] = make(map[uint64]map[int64]bool)
+	}
+	return &index
+}
+
+//mixes the bits of a 64 bit value, from splitmix64
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+//returns the hash of each band of the MinHash signature of a set of tokens
+func lshBands(tokens []string) []uint64 {
+	var signature [minhashBands * minhashRows]uint64
+	for i := range signature {
+		signature[i] = ^uint64(0)
+	}
+	for _, t := range tokens {
+		h := fnv.New64a()
+		h.Write([]byte(t))
+		value := h.Sum64()
+		for i := range signature {
+			if v := mix(value ^ uint64(i)*0x9e3779b97f4a7c15); v < signature[i] {
+				signature[i] = v
+			}
+		}
+	}
+
+	bands := make([]uint64, minhashBands)
+	for b := range bands {
+		key := uint64(b)
+		for r := 0; r < minhashRows; r++ {
+			key = mix(key ^ signature[b*minhashRows+r])
+		}
+		bands[b] = key
+	}
+	return bands
+}
+
+//adds an unmatched line to the index
+func (d *Detector) addCandidate(u unmatchedLog) {
+	if d.lsh == nil {
+		return
+	}
+	for b, key := range u.bands {
+		slots := d.lsh[b][key]
+		if slots == nil {
+			slots = make(map[int64]bool)
+			d.lsh[b][key] = slots
+		}
+		slots[u.slot] = true
+	}
+}
+
+//removes an unmatched line from the index, it must be called whenever a line leaves the unmatched list
+func (d *Detector) forgetCandidate(u unmatchedLog) {
+	if d.lsh == nil {
+		return
+	}
+	for b, key := range u.bands {
+		slots := d.lsh[b][key]
+		delete(slots, u.slot)
+		if len(slots) == 0 {
+			delete(d.lsh[b], key)
+		}
+	}
+}
+
+//returns the slots, in the order they were stored, of at most Options.Candidates unmatched lines stored
+//after the supplied slot.  The lines sharing the most bands with the line are chosen.
+func (d *Detector) candidates(bands []uint64, after int64) []int64 {
+	shared := make(map[int64]int)
+	for b, key := range bands {
+		for slot := range d.lsh[b][key] {
+			if slot > after {
+				shared[slot]++
+			}
+		}
+	}
+
+	slots := make([]int64, 0, len(shared))
+	for slot := range shared {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		if shared[slots[i]] != shared[slots[j]] {
+			return shared[slots[i]] > shared[slots[j]]
+		}
+		return slots[i] < slots[j]
+	})
+	if len(slots) > d.opts.Candidates {
+		slots = slots[:d.opts.Candidates]
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+	return slots
+}
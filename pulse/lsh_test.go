@@ -0,0 +1,57 @@
+package pulse_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestCandidatesFindSimilarLines(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Candidates = 4
+	d := New(opts)
+
+	//fill the unmatched list with lines that have nothing in common
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		d.Analyze(fmt.Sprintf("%x %x %x", r.Int63(), r.Int63(), r.Int63()))
+	}
+	d.Analyze("user alice logged in from host one")
+	d.Analyze("user bob logged in from host two")
+
+	if m := d.Memory(); m.Patterns != 1 {
+		t.Errorf("Candidates did not propose the similar line")
+		t.Logf("Expected: 1 pattern")
+		t.Logf("Actual: %d patterns", m.Patterns)
+	}
+}
+
+func TestCandidatesOnKernLog(t *testing.T) {
+	lines := kernLog(t, 500)
+	count := func(candidates int) int {
+		opts := kernOptions()
+		opts.Candidates = candidates
+		d := New(opts)
+		for _, line := range lines {
+			d.Analyze(line)
+		}
+		return d.Memory().Patterns
+	}
+
+	//pruning may miss a few pairs, but should learn nearly every pattern
+	exhaustive := count(0)
+	pruned := count(8)
+	if pruned < exhaustive*9/10 {
+		t.Errorf("Candidates learned too few patterns")
+		t.Logf("Expected: about %d", exhaustive)
+		t.Logf("Actual: %d", pruned)
+	}
+}
+
+func BenchmarkKernLogCandidates(b *testing.B) {
+	opts := kernOptions()
+	opts.Candidates = 8
+	benchmarkKernLog(b, opts)
+}
@@ -0,0 +1,222 @@
+package pulse
+
+import (
+	"time"
+	"unsafe"
+)
+
+//Eviction chooses which pattern is forgotten when Options.MaxPatterns is reached
+type Eviction int
+
+const (
+	//EvictLRU forgets the pattern that matched least recently
+	EvictLRU Eviction = iota
+	//EvictLFU forgets the pattern that has matched the fewest lines, the least recent of those first
+	EvictLFU
+)
+
+//otherVariation collects the values of a wildcard slot once Options.MaxVariations distinct values are kept
+const otherVariation = "!OTHER!"
+
+//estimated cost of one id in the index, an id key and a bool value
+const indexEntryBytes = int64(unsafe.Sizeof(int64(0))) + 1
+
+//estimated cost of one word in the index, the string header and the pointer to its set of ids
+const indexWordBytes = int64(unsafe.Sizeof("")) + int64(unsafe.Sizeof(map[int64]bool{}))
+
+//MemoryStats is an estimate of the memory held by each structure of a Detector.
+//Byte counts include the strings each structure holds but not allocator overhead.
+//The models kept per source or label are added together.
+type MemoryStats struct {
+	//Patterns is the number of learned patterns
+	Patterns int
+	//Tokens is the number of tokens in all patterns
+	Tokens int
+	//Variations is the number of distinct wildcard values kept in all patterns
+	Variations int
+	//PatternBytes is the memory held by patterns, their tokens, variations and numeric statistics
+	PatternBytes int64
+	//Unmatched is the number of lines waiting for a pattern
+	Unmatched int
+	//UnmatchedBytes is the memory held by the unmatched lines
+	UnmatchedBytes int64
+	//IndexWords is the number of distinct words in the index used to lookup patterns
+	IndexWords int
+	//IndexEntries is the number of pattern ids held by the index
+	IndexEntries int
+	//IndexBytes is the memory held by the index
+	IndexBytes int64
+	//EvictedPatterns is how many patterns have been forgotten because of Options.MaxPatterns
+	EvictedPatterns int64
+	//DroppedUnmatched is how many unmatched lines have been dropped because of
+	//Options.MaxUnmatched or Options.UnmatchedTTL
+	DroppedUnmatched int64
+	//SequenceEntries is the number of counts kept by the sequence model, see Options.SequenceOrder
+	SequenceEntries int
+	//SequenceBytes is the memory held by the sequence model
+	SequenceBytes int64
+}
+
+//TotalBytes returns the estimated memory held by all structures
+func (m MemoryStats) TotalBytes() int64 {
+	return m.PatternBytes + m.UnmatchedBytes + m.IndexBytes + m.SequenceBytes
+}
+
+//Memory returns the current size of the model
+func (d *Detector) Memory() MemoryStats {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	m := MemoryStats{
+		Patterns:         len(d.patterns),
+		Unmatched:        len(d.unmatched),
+		EvictedPatterns:  d.evictedPatterns,
+		DroppedUnmatched: d.droppedUnmatched,
+	}
+	for _, p := range d.patterns {
+		m.PatternBytes += int64(unsafe.Sizeof(*p))
+		for _, t := range p.tokens {
+			m.Tokens++
+			m.PatternBytes += int64(unsafe.Sizeof(t)) + int64(len(t.word))
+			for _, v := range t.variations {
+				m.Variations++
+				m.PatternBytes += int64(unsafe.Sizeof(v)) + int64(len(v.text))
+			}
+			if t.stats != nil {
+				m.PatternBytes += int64(unsafe.Sizeof(*t.stats))
+				m.PatternBytes += int64(len(t.stats.digest.centroids)+len(t.stats.digest.buffer)) * int64(unsafe.Sizeof(centroid{}))
+			}
+		}
+	}
+	for _, u := range d.unmatched {
+		m.UnmatchedBytes += int64(unsafe.Sizeof(u)) + int64(len(u.line)+len(u.body)+len(u.pattern))
+		m.UnmatchedBytes += int64(len(u.header.Format) + len(u.header.Host) + len(u.header.App) + len(u.header.PID))
+		//the first line of an event is the line itself
+		for i := 1; i < len(u.lines); i++ {
+			m.UnmatchedBytes += int64(unsafe.Sizeof(u.lines[i])) + int64(len(u.lines[i]))
+		}
+		//the band hashes, and an entry in the candidate index for each
+		m.UnmatchedBytes += int64(len(u.bands)) * (int64(unsafe.Sizeof(uint64(0))) + indexEntryBytes)
+	}
+	for word, ids := range d.index {
+		m.IndexWords++
+		m.IndexEntries += len(ids)
+		m.IndexBytes += indexWordBytes + int64(len(word))
+	}
+	m.IndexBytes += int64(m.IndexEntries) * indexEntryBytes
+	if s := d.sequence; s != nil {
+		for key, next := range s.transitions {
+			m.SequenceEntries += len(next)
+			m.SequenceBytes += indexWordBytes*2 + int64(len(key)*2)
+		}
+		for _, followers := range s.followers {
+			m.SequenceEntries += len(followers)
+			m.SequenceBytes += indexWordBytes * 2
+		}
+		m.SequenceBytes += int64(m.SequenceEntries) * indexEntryBytes
+		for _, open := range s.open {
+			m.SequenceBytes += int64(len(open)) * int64(unsafe.Sizeof(occurrence{}))
+		}
+	}
+	for _, c := range d.childList() {
+		m.add(c.Memory())
+	}
+	return m
+}
+
+//adds the stats of a child that keeps the model of a source or label
+func (m *MemoryStats) add(o MemoryStats) {
+	m.Patterns += o.Patterns
+	m.Tokens += o.Tokens
+	m.Variations += o.Variations
+	m.PatternBytes += o.PatternBytes
+	m.Unmatched += o.Unmatched
+	m.UnmatchedBytes += o.UnmatchedBytes
+	m.IndexWords += o.IndexWords
+	m.IndexEntries += o.IndexEntries
+	m.IndexBytes += o.IndexBytes
+	m.EvictedPatterns += o.EvictedPatterns
+	m.DroppedUnmatched += o.DroppedUnmatched
+	m.SequenceEntries += o.SequenceEntries
+	m.SequenceBytes += o.SequenceBytes
+}
+
+//forgets patterns until there are no more than Options.MaxPatterns, keep is never forgotten
+func (d *Detector) evictPatterns(keep *pattern) {
+	if d.opts.MaxPatterns <= 0 {
+		return
+	}
+	for len(d.patterns) > d.opts.MaxPatterns {
+		victim := -1
+		for i, p := range d.patterns {
+			//patterns an operator gave feedback about are kept
+			if p == keep || p.pin != pinNone {
+				continue
+			}
+			if victim < 0 || d.evictBefore(p, d.patterns[victim]) {
+				victim = i
+			}
+		}
+		if victim < 0 {
+			return
+		}
+
+		d.unindexPattern(d.patterns[victim])
+		if d.sequence != nil {
+			d.sequence.forget(d.patterns[victim].id)
+		}
+		d.patterns = append(d.patterns[:victim], d.patterns[victim+1:]...)
+		d.evictedPatterns++
+	}
+}
+
+//returns true if a should be forgotten before b
+func (d *Detector) evictBefore(a, b *pattern) bool {
+	if d.opts.Eviction == EvictLFU && a.numMatches != b.numMatches {
+		return a.numMatches < b.numMatches
+	}
+	return a.lastSeen < b.lastSeen
+}
+
+//drops unmatched lines older than Options.UnmatchedTTL, offering any that were never reported first.
+//lines are stored in the order they arrive, so only the front of the list needs to be checked.
+func (d *Detector) expireUnmatched(now time.Time) {
+	if d.opts.UnmatchedTTL <= 0 {
+		return
+	}
+	expired := 0
+	for expired < len(d.unmatched) && now.Sub(d.unmatched[expired].dateStored) > d.opts.UnmatchedTTL {
+		if !d.unmatched[expired].reported {
+			d.reportAnomaly(d.unmatchedAnomaly(d.unmatched[expired], ReasonTimedOut))
+		}
+		d.forgetCandidate(d.unmatched[expired])
+		expired++
+	}
+	if expired > 0 {
+		d.unmatched = append(d.unmatched[:0], d.unmatched[expired:]...)
+		d.droppedUnmatched += int64(expired)
+	}
+}
+
+//drops unmatched lines until there are no more than Options.MaxUnmatched.  The oldest line that was
+//already reported goes first, if every line is still pending the oldest is offered and dropped.
+func (d *Detector) trimUnmatched() {
+	if d.opts.MaxUnmatched <= 0 {
+		return
+	}
+	for len(d.unmatched) > d.opts.MaxUnmatched {
+		index := 0
+		for i := range d.unmatched {
+			if d.unmatched[i].reported {
+				index = i
+				break
+			}
+		}
+		if !d.unmatched[index].reported {
+			d.reportAnomaly(d.unmatchedAnomaly(d.unmatched[index], ReasonNeverMatched))
+		}
+		d.forgetCandidate(d.unmatched[index])
+		d.unmatched = append(d.unmatched[:index], d.unmatched[index+1:]...)
+		d.droppedUnmatched++
+	}
+}
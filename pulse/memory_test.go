@@ -0,0 +1,87 @@
+package pulse_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestMaxPatterns(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxPatterns = 2
+	d := New(opts)
+
+	families := []string{
+		"user u%d logged in from host h%d",
+		"disk sd%d is %d percent full",
+		"backup job %d finished after %d seconds",
+	}
+	for _, family := range families {
+		for i := 0; i < 5; i++ {
+			d.Analyze(fmt.Sprintf(family, i, i*7))
+		}
+	}
+
+	//a line of the forgotten pattern must not find it through the index
+	d.Analyze(fmt.Sprintf(families[0], 9, 63))
+
+	m := d.Memory()
+	if m.Patterns != 2 {
+		t.Errorf("Pattern count does not match")
+		t.Logf("Expected: 2")
+		t.Logf("Actual: %d", m.Patterns)
+	}
+	if m.EvictedPatterns != 1 {
+		t.Errorf("Evicted count does not match")
+		t.Logf("Expected: 1")
+		t.Logf("Actual: %d", m.EvictedPatterns)
+	}
+	if m.PatternBytes <= 0 || m.IndexEntries <= 0 || m.TotalBytes() < m.PatternBytes {
+		t.Errorf("Memory was not accounted for: %+v", m)
+	}
+}
+
+func TestMaxUnmatched(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxUnmatched = 3
+	d := New(opts)
+
+	lines := []string{
+		"kernel panic",
+		"0123456789",
+		"!!!! ????",
+		"zzzzzzzzzzzzzzzz",
+		"a b c d e f g h",
+		"segfault at 0",
+	}
+	for _, line := range lines {
+		d.Analyze(line)
+	}
+
+	m := d.Memory()
+	if m.Unmatched != 3 || m.DroppedUnmatched != int64(len(lines)-3) {
+		t.Errorf("Unmatched lines were not capped: %+v", m)
+	}
+}
+
+func TestMaxVariations(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxVariations = 4
+	d := New(opts)
+
+	for i := 0; i < 50; i++ {
+		d.Analyze(fmt.Sprintf("user u%d logged in from host h%d", i, i))
+	}
+
+	m := d.Memory()
+	if m.Patterns != 1 {
+		t.Fatalf("Expected 1 pattern, got %d", m.Patterns)
+	}
+	//two slots, each with four values and the bucket for the rest
+	if m.Variations > 10 {
+		t.Errorf("Variations were not capped")
+		t.Logf("Expected: at most 10")
+		t.Logf("Actual: %d", m.Variations)
+	}
+}
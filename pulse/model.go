@@ -0,0 +1,338 @@
+package pulse
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+//modelMagic starts every saved model so other files are rejected early
+const modelMagic = "PULSEMODEL"
+
+//modelVersion is bumped whenever the saved layout changes in a way older readers cannot handle
+const modelVersion uint32 = 1
+
+//snapshot types mirror the internal model with exported fields so they can be gob encoded
+type modelSnapshot struct {
+	Patterns                      []patternSnapshot
+	Unmatched                     []unmatchedSnapshot
+	Seq                           int64
+	PatternCreationRate           float64
+	PatternCreationRateIncreasing bool
+	InputsSinceLastNewPattern     int64
+	LastPatternCount              int
+	LogTime                       time.Time
+	LastDecay                     time.Time
+	WindowStart                   time.Time
+	NextID                        int64
+	Trained                       bool
+	NextAnomalyID                 int64
+	History                       []Anomaly
+	Sequence                      *sequenceSnapshot
+	//the models kept per source or label, see Options.SplitBySource
+	Children map[string]*modelSnapshot
+}
+
+type patternSnapshot struct {
+	ID          int64
+	Tokens      []tokenSnapshot
+	NumMatches  int64
+	LastSeen    int64
+	Rate        rateSnapshot
+	Pin         int8
+	FirstSeen   time.Time
+	LastMatched time.Time
+}
+
+type rateSnapshot struct {
+	WindowStartMatches int64
+	Mean               float64
+	Windows            int
+}
+
+type tokenSnapshot struct {
+	Word       string
+	Variable   bool
+	Required   bool
+	Variations []variationSnapshot
+	Kind       Kind
+	Samples    int64
+	Stats      *statsSnapshot
+	Stable     int64
+}
+
+type statsSnapshot struct {
+	Count     int64
+	Mean      float64
+	M2        float64
+	Centroids []centroidSnapshot
+	Total     float64
+	Min       float64
+	Max       float64
+}
+
+type centroidSnapshot struct {
+	Mean   float64
+	Weight float64
+}
+
+type variationSnapshot struct {
+	Text       string
+	NumMatches int64
+}
+
+//the counts of the sequence model, the matches waiting for their timeout are not kept
+type sequenceSnapshot struct {
+	Contexts    map[string]int64
+	Transitions map[string]map[int64]int64
+	Closed      map[int64]int64
+	Followers   map[int64]map[int64]int64
+}
+
+type unmatchedSnapshot struct {
+	Line       string
+	Lines      []string
+	Body       string
+	Header     Header
+	DateStored time.Time
+	Reported   bool
+	Seq        int64
+	Pattern    string
+	PatternID  int64
+	Score      float64
+	Source     string
+	Labels     map[string]string
+}
+
+//Save writes the learned model to w so it can be restored later with Load
+func (d *Detector) Save(w io.Writer) error {
+	d.mu.RLock()
+	snap := d.snapshot()
+	d.mu.RUnlock()
+
+	if _, err := io.WriteString(w, modelMagic); err != nil {
+		return fmt.Errorf("pulse.Save: %s", err)
+	}
+	if err := binary.Write(w, binary.BigEndian, modelVersion); err != nil {
+		return fmt.Errorf("pulse.Save: %s", err)
+	}
+	if err := gob.NewEncoder(w).Encode(snap); err != nil {
+		return fmt.Errorf("pulse.Save: %s", err)
+	}
+	return nil
+}
+
+//Load reads a model written by Save and returns a Detector using the default options
+func Load(r io.Reader) (*Detector, error) {
+	return LoadWithOptions(r, DefaultOptions())
+}
+
+//LoadWithOptions reads a model written by Save and returns a Detector using opts
+func LoadWithOptions(r io.Reader, opts Options) (*Detector, error) {
+	magic := make([]byte, len(modelMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("pulse.Load: %s", err)
+	}
+	if string(magic) != modelMagic {
+		return nil, fmt.Errorf("pulse.Load: not a pulse model")
+	}
+
+	var version uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, fmt.Errorf("pulse.Load: %s", err)
+	}
+	if version != modelVersion {
+		return nil, fmt.Errorf("pulse.Load: unsupported model version %d", version)
+	}
+
+	var snap modelSnapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("pulse.Load: %s", err)
+	}
+
+	d := New(opts)
+	d.restore(snap)
+	return d, nil
+}
+
+//copies the model into a snapshot, the caller must hold the lock
+func (d *Detector) snapshot() modelSnapshot {
+	snap := modelSnapshot{
+		Seq:                           d.seq,
+		PatternCreationRate:           d.patternCreationRate,
+		PatternCreationRateIncreasing: d.patternCreationRateIncreasing,
+		InputsSinceLastNewPattern:     d.inputsSinceLastNewPattern,
+		LastPatternCount:              d.lastPatternCount,
+		LogTime:                       d.logTime,
+		LastDecay:                     d.lastDecay,
+		WindowStart:                   d.windowStart,
+		NextID:                        d.nextID,
+		Trained:                       d.trained,
+		NextAnomalyID:                 atomic.LoadInt64(&d.nextAnomalyID),
+		History:                       d.recalled(),
+	}
+	if s := d.sequence; s != nil {
+		snap.Sequence = s.snapshot()
+	}
+
+	for _, p := range d.patterns {
+		ps := patternSnapshot{
+			ID:          p.id,
+			NumMatches:  p.numMatches,
+			LastSeen:    p.lastSeen,
+			Rate:        rateSnapshot{p.rate.windowStartMatches, p.rate.mean, p.rate.windows},
+			Pin:         int8(p.pin),
+			FirstSeen:   p.firstSeen,
+			LastMatched: p.lastMatched,
+		}
+		for _, t := range p.tokens {
+			ts := tokenSnapshot{Word: t.word, Variable: t.variable, Required: t.required, Kind: t.kind, Samples: t.samples, Stable: t.stable}
+			if s := t.stats; s != nil {
+				ts.Stats = &statsSnapshot{Count: s.count, Mean: s.mean, M2: s.m2, Total: s.digest.total, Min: s.digest.min, Max: s.digest.max}
+				//the lock is only held for reading, so the buffered values are merged into a copy
+				for _, c := range s.digest.merged() {
+					ts.Stats.Centroids = append(ts.Stats.Centroids, centroidSnapshot{c.mean, c.weight})
+				}
+			}
+			for _, v := range t.variations {
+				ts.Variations = append(ts.Variations, variationSnapshot{v.text, v.numMatches})
+			}
+			ps.Tokens = append(ps.Tokens, ts)
+		}
+		snap.Patterns = append(snap.Patterns, ps)
+	}
+
+	for _, u := range d.unmatched {
+		snap.Unmatched = append(snap.Unmatched, unmatchedSnapshot{
+			Line:       u.line,
+			Lines:      u.lines,
+			Body:       u.body,
+			Header:     u.header,
+			DateStored: u.dateStored,
+			Reported:   u.reported,
+			Seq:        u.seq,
+			Pattern:    u.pattern,
+			PatternID:  u.patternID,
+			Score:      u.score,
+			Source:     u.source,
+			Labels:     u.labels,
+		})
+	}
+
+	for key, c := range d.children {
+		c.mu.RLock()
+		cs := c.snapshot()
+		c.mu.RUnlock()
+		if snap.Children == nil {
+			snap.Children = make(map[string]*modelSnapshot)
+		}
+		snap.Children[key] = &cs
+	}
+
+	return snap
+}
+
+//replaces the model with the contents of a snapshot and rebuilds the index
+func (d *Detector) restore(snap modelSnapshot) {
+	d.seq = snap.Seq
+	d.patternCreationRate = snap.PatternCreationRate
+	d.patternCreationRateIncreasing = snap.PatternCreationRateIncreasing
+	d.inputsSinceLastNewPattern = snap.InputsSinceLastNewPattern
+	d.lastPatternCount = snap.LastPatternCount
+	d.logTime = snap.LogTime
+	d.lastDecay = snap.LastDecay
+	d.windowStart = snap.WindowStart
+	d.nextID = snap.NextID
+	d.trained = snap.Trained
+	atomic.StoreInt64(&d.nextAnomalyID, snap.NextAnomalyID)
+	d.history, d.historyNext = nil, 0
+	for _, a := range snap.History {
+		d.remember(a)
+	}
+	if d.sequence != nil {
+		d.sequence = newSequenceModel()
+		if seq := snap.Sequence; seq != nil {
+			for key, count := range seq.Contexts {
+				d.sequence.contexts[key] = count
+			}
+			for key, next := range seq.Transitions {
+				d.sequence.transitions[key] = next
+			}
+			for id, count := range seq.Closed {
+				d.sequence.closed[id] = count
+			}
+			for id, followers := range seq.Followers {
+				d.sequence.followers[id] = followers
+			}
+		}
+	}
+
+	//models saved before patterns had ids are given new ones by indexPattern
+	d.patterns = nil
+	d.index = make(tokenIndex)
+	d.byID = make(map[int64]*pattern)
+	for _, ps := range snap.Patterns {
+		p := &pattern{
+			id:          ps.ID,
+			numMatches:  ps.NumMatches,
+			lastSeen:    ps.LastSeen,
+			rate:        patternRate{ps.Rate.WindowStartMatches, ps.Rate.Mean, ps.Rate.Windows},
+			pin:         pin(ps.Pin),
+			firstSeen:   ps.FirstSeen,
+			lastMatched: ps.LastMatched,
+		}
+		for _, ts := range ps.Tokens {
+			t := token{word: ts.Word, variable: ts.Variable, required: ts.Required, kind: ts.Kind, samples: ts.Samples, stable: ts.Stable}
+			if ss := ts.Stats; ss != nil {
+				t.stats = &numericStats{count: ss.Count, mean: ss.Mean, m2: ss.M2, digest: digest{total: ss.Total, min: ss.Min, max: ss.Max}}
+				for _, c := range ss.Centroids {
+					t.stats.digest.centroids = append(t.stats.digest.centroids, centroid{c.Mean, c.Weight})
+				}
+			}
+			for _, v := range ts.Variations {
+				t.variations = append(t.variations, variation{v.Text, v.NumMatches})
+			}
+			p.tokens = append(p.tokens, t)
+		}
+		d.patterns = append(d.patterns, p)
+		d.indexPattern(p)
+	}
+
+	d.unmatched = nil
+	d.view = nil
+	if d.lsh != nil {
+		d.lsh = newLSHIndex()
+	}
+	for _, u := range snap.Unmatched {
+		var entry = unmatchedLog{
+			line:       u.Line,
+			lines:      u.Lines,
+			body:       u.Body,
+			header:     u.Header,
+			dateStored: u.DateStored,
+			reported:   u.Reported,
+			seq:        u.Seq,
+			pattern:    u.Pattern,
+			patternID:  u.PatternID,
+			score:      u.Score,
+			slot:       int64(len(d.unmatched) + 1),
+			source:     u.Source,
+			labels:     u.Labels,
+		}
+		if d.lsh != nil {
+			entry.bands = lshBands(d.opts.Tokenizer.Tokenize(entry.body))
+		}
+		d.unmatched = append(d.unmatched, entry)
+		d.addCandidate(entry)
+	}
+	d.unmatchedSlots = int64(len(d.unmatched))
+
+	//children are restored even if the options no longer split the input, so feedback about them still works
+	d.children = nil
+	for key, cs := range snap.Children {
+		d.child(key).restore(*cs)
+	}
+}
@@ -0,0 +1,153 @@
+package pulse
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+//DefaultMultilineMaxLines is the most lines an event may have when Multiline.MaxLines is not set
+const DefaultMultilineMaxLines = 500
+
+//Multiline decides which lines continue the event started by an earlier line, such as the frames of a
+//stack trace or the lines of a kernel oops.  A line continues the event when any of the rules that are
+//set says so.  Rules look at the message, after any header ParseHeader recognizes, so indented kernel
+//lines behind a syslog header still count as indented.
+//The pattern of an event is learned from its first line, and the whole event is attached to its Anomaly.
+type Multiline struct {
+	//Indented continues the event with lines whose message starts with a space or a tab
+	Indented bool
+
+	//Prefixes continues the event with lines whose message, leading spaces removed, starts with one of them,
+	//such as "at " and "Caused by:" for Java stack traces
+	Prefixes []string
+
+	//NoHeader continues the event with lines that have no header ParseHeader recognizes
+	NoHeader bool
+
+	//MaxIdle, when set, ends an event no line has been added to for this long by Options.Clock.
+	//Run and RunWithHandler then analyze it without waiting for the line that starts the next event.
+	MaxIdle time.Duration
+
+	//MaxLines is the most lines an event may have, a further line starts a new event.  Default 500.
+	MaxLines int
+}
+
+//returns true if any rule is set, otherwise every line is an event of its own
+func (m Multiline) enabled() bool {
+	return m.Indented || len(m.Prefixes) > 0 || m.NoHeader
+}
+
+//returns true if the line continues the event before it
+func (m Multiline) continues(line string) bool {
+	header, body := ParseHeader(line)
+	if m.NoHeader && header.Format == "" {
+		return true
+	}
+	if m.Indented && (strings.HasPrefix(body, " ") || strings.HasPrefix(body, "\t")) {
+		return true
+	}
+	trimmed := strings.TrimLeft(body, " \t")
+	for _, prefix := range m.Prefixes {
+		if strings.HasPrefix(trimmed, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//joins lines into events.  It has its own lock, as with Options.Workers lines are assembled
+//by the goroutine reading them before the detector's lock is taken.
+type assembler struct {
+	mu    sync.Mutex
+	rules Multiline
+	event []Record
+	last  time.Time
+}
+
+//adds a line read at now and returns the event it ends, or nil if the line continues the pending event
+func (a *assembler) add(r Record, now time.Time) []Record {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var done []Record
+	if len(a.event) > 0 && (a.idle(now) || len(a.event) >= a.rules.MaxLines || !a.rules.continues(r.Line)) {
+		done, a.event = a.event, nil
+	}
+	a.event = append(a.event, r)
+	a.last = now
+	return done
+}
+
+//returns the pending event if it has been idle for MaxIdle at now, or nil
+func (a *assembler) expire(now time.Time) []Record {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.event) == 0 || !a.idle(now) {
+		return nil
+	}
+	done := a.event
+	a.event = nil
+	return done
+}
+
+//returns the pending event whatever its age, or nil
+func (a *assembler) flush() []Record {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	done := a.event
+	a.event = nil
+	return done
+}
+
+//returns how long until the pending event is idle at now, false if there is none or MaxIdle is not set
+func (a *assembler) wait(now time.Time) (time.Duration, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.event) == 0 || a.rules.MaxIdle <= 0 {
+		return 0, false
+	}
+	return a.last.Add(a.rules.MaxIdle).Sub(now), true
+}
+
+func (a *assembler) idle(now time.Time) bool {
+	return a.rules.MaxIdle > 0 && now.Sub(a.last) >= a.rules.MaxIdle
+}
+
+//returns the event the line ends, the line itself when Options.Multiline is not set
+func (d *Detector) assemble(r Record) []Record {
+	if d.events == nil {
+		return []Record{r}
+	}
+	return d.events.add(r, d.opts.Clock.Now())
+}
+
+//returns a channel that delivers once a pending event, of the detector or one of its children, has been
+//idle for Multiline.MaxIdle, or nil if there is nothing to wait for
+func (d *Detector) idle() <-chan time.Time {
+	var wait time.Duration
+	var now time.Time
+	found := false
+	for _, c := range d.detectors() {
+		if c.events == nil {
+			continue
+		}
+		if now.IsZero() {
+			now = d.opts.Clock.Now()
+		}
+		if w, ok := c.events.wait(now); ok && (!found || w < wait) {
+			wait, found = w, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return time.After(wait)
+}
+
+//returns the pending event if it has been idle for Multiline.MaxIdle, or nil
+func (d *Detector) expireEvent() []Record {
+	if d.events == nil {
+		return nil
+	}
+	return d.events.expire(d.opts.Clock.Now())
+}
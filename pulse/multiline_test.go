@@ -0,0 +1,82 @@
+package pulse_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestMultilineJoinsStackTrace(t *testing.T) {
+	var lines []string
+	for i := 0; i < 40; i++ {
+		lines = append(lines, fmt.Sprintf("user u%d logged in from host h%d", i, i))
+	}
+	trace := []string{
+		"java.lang.NullPointerException: name is null",
+		"\tat com.example.Login.check(Login.java:42)",
+		"\tat com.example.Login.run(Login.java:17)",
+		"Caused by: java.io.IOException: stream closed",
+	}
+	lines = append(lines, trace...)
+	lines = append(lines, "user u40 logged in from host h40")
+
+	opts := DefaultOptions()
+	opts.Multiline = Multiline{Indented: true, Prefixes: []string{"Caused by:"}}
+	var event *Anomaly
+	for _, a := range runLines(opts, lines) {
+		if strings.HasPrefix(a.Line, "\tat ") || strings.HasPrefix(a.Line, "Caused by:") {
+			t.Errorf("A continuation line was reported on its own: %s", a.Line)
+		}
+		if a.Line == trace[0] {
+			a := a
+			event = &a
+		}
+	}
+
+	if event == nil {
+		t.Fatalf("The stack trace was not reported")
+	}
+	if strings.Join(event.Lines, "\n") != strings.Join(trace, "\n") {
+		t.Errorf("The anomaly did not carry the whole stack trace")
+		t.Logf("Expected: %q", trace)
+		t.Logf("Actual: %q", event.Lines)
+	}
+}
+
+func TestMultilineMaxIdle(t *testing.T) {
+	found := make(chan Anomaly, 100)
+	opts := DefaultOptions()
+	opts.Multiline = Multiline{Indented: true, MaxIdle: 20 * time.Millisecond}
+	d := New(opts)
+	in := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.RunWithHandler(ctx, in, func(a Anomaly) { found <- a })
+
+	for i := 0; i < 40; i++ {
+		in <- fmt.Sprintf("user u%d logged in from host h%d", i, i)
+	}
+	in <- "panic: runtime error"
+	in <- "  goroutine 1 [running]:"
+
+	//in stays open, so only MaxIdle can end the event
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case a := <-found:
+			if a.Line != "panic: runtime error" {
+				continue
+			}
+			if len(a.Lines) != 2 {
+				t.Errorf("Expected 2 lines in the event, got %d", len(a.Lines))
+			}
+			return
+		case <-timeout:
+			t.Fatalf("The idle event was not analyzed")
+		}
+	}
+}
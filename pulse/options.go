@@ -0,0 +1,380 @@
+package pulse
+
+import "time"
+
+//Default values for Options.  They are the values the algorithm was tuned with.
+const (
+	DefaultTokenMatchRatio     = 0.5
+	DefaultSimilarityThreshold = 0.5
+	DefaultUnmatchedTimeout    = 30 * time.Second
+	DefaultLengthSimilarity    = 0.90
+	DefaultCreationRateGate    = 0.20
+	DefaultRateDecay           = 0.99
+	DefaultVertexDistance      = 2
+	DefaultVertexPreference    = 3
+	DefaultKindMinSamples      = 20
+	DefaultRareWarmup          = 10000
+	DefaultRateAlpha           = 0.3
+	DefaultRateSpike           = 3.0
+	DefaultRateDrop            = 0.2
+	DefaultRateMinBaseline     = 5.0
+	DefaultRateWarmup          = 5
+	DefaultMergeSimilarity     = 0.75
+	DefaultHistory             = 1000
+	DefaultSequenceMinProb     = 0.01
+	DefaultSequenceWarmup      = 100
+	DefaultSequenceExpected    = 0.95
+	DefaultSequenceMaxOpen     = 1000
+	DefaultOutlierMinSamples   = 100
+	DefaultOutlierQuantile     = 0.001
+	DefaultOutlierDeviations   = 4.0
+	DefaultNewValueMaxCard     = 20
+	DefaultNewValueStable      = 200
+)
+
+//Options configures a Detector.  Any field left at its zero value uses its default.
+type Options struct {
+	//Source names the stream the detector reads, it is copied onto every Anomaly whose Record has no Source
+	Source string
+
+	//SplitBySource keeps a separate model for each Record.Source, so patterns of nginx and kernel logs
+	//are not mixed.  Each model is a Detector of its own, with these options, created the first time its
+	//source is seen.  The models share Options.Workers, the handler and anomaly ids, and are saved together.
+	SplitBySource bool
+
+	//SplitByLabel, when set, keeps a separate model for each value of this key of Record.Labels
+	//in the same way as SplitBySource.  It takes precedence over SplitBySource.  Rate anomalies, which are
+	//not about a single record, carry the label of their model in Anomaly.Labels.
+	SplitByLabel string
+
+	//TokenMatchRatio is the fraction of a line's tokens that must be found in a pattern
+	//before the line is matched against that pattern.  Default 0.5.
+	TokenMatchRatio float64
+
+	//SimilarityThreshold is the Levenshtein similarity, from 0 to 1, an unmatched line must have
+	//with a new line before a pattern is searched for between the two.  Default 0.5.
+	SimilarityThreshold float64
+
+	//UnmatchedTimeout is how long a line may stay unmatched before it is reported as timed out.
+	//Default 30 seconds.
+	UnmatchedTimeout time.Duration
+
+	//LengthSimilarity is how close, from 0 to 1, the number of tokens in a pattern and a longer
+	//revision of it must be for the line to still count as a match.  Default 0.90.
+	LengthSimilarity float64
+
+	//CreationRateGate is the pattern creation rate below which anomalies are reported even while the
+	//rate is increasing.  A higher value reports more while the model is still learning.  Default 0.20.
+	CreationRateGate float64
+
+	//RateDecay is multiplied into the pattern creation rate for every line that does not create
+	//a pattern.  Default 0.99.
+	RateDecay float64
+
+	//VertexDistance is the largest step between shared tokens that is still treated as
+	//contiguous, larger steps become wildcards.  Default 2.
+	VertexDistance int
+
+	//VertexPreference is how much further away a shared token may be and still be preferred
+	//when it starts a longer run of shared tokens.  Default 3.
+	VertexPreference int
+
+	//Clock tells the detector the time when ParseTime is not set or before any line had a timestamp.
+	//Default SystemClock.
+	Clock Clock
+
+	//ParseTime, when set, takes the time of each line from a timestamp in the line.
+	//Timeouts and rate decay then run in log time, so replaying an old file behaves like live input.
+	ParseTime TimeParser
+
+	//DecayInterval, when set, applies RateDecay once per interval of clock time instead of once per line.
+	DecayInterval time.Duration
+
+	//StripHeaders removes syslog, ISO-8601, apache and kernel headers from each line before it is
+	//tokenized, so patterns are learned only from the message.  See ParseHeader.
+	StripHeaders bool
+
+	//HeaderTime takes the time of each line from the timestamp in its stripped header, in the same way
+	//as ParseTime.  It needs StripHeaders and is ignored when ParseTime is set.
+	HeaderTime bool
+
+	//Tokenizer splits each message into tokens.  Default DefaultTokenizer.
+	Tokenizer Tokenizer
+
+	//ReportTypeMismatch reports a line whose value in a wildcard slot does not fit the kind the slot
+	//has learned, such as text where only integers were seen.
+	ReportTypeMismatch bool
+
+	//KindMinSamples is how many values a slot must see before its kind is fixed and
+	//ReportTypeMismatch can report values that do not fit it.  Default 20.
+	KindMinSamples int
+
+	//ReportOutliers reports a line whose number or duration in a wildcard slot is far outside the range
+	//the slot has learned, such as a request that took 98000ms where 45ms is usual.  Values reported are
+	//not learned, and the expected range is given in Anomaly.Expected.
+	ReportOutliers bool
+
+	//OutlierMinSamples is how many numbers a slot must see before ReportOutliers reports values in it.  Default 100.
+	OutlierMinSamples int
+
+	//OutlierQuantile is the fraction of the values learned that may lie below, and above, the expected
+	//range of a slot.  Default 0.001.
+	OutlierQuantile float64
+
+	//OutlierDeviations is how many standard deviations from the mean the expected range of a slot
+	//spans at least.  Default 4.
+	OutlierDeviations float64
+
+	//ReportNewValues reports a line with a value never seen before in a wildcard slot that holds a small
+	//set of values, such as eth7 where only eth0 and eth1 were seen, or a status of 500 where only 200 and
+	//304 were.  The values the slot has seen are given in Anomaly.Expected.
+	ReportNewValues bool
+
+	//NewValueMaxCardinality is the most distinct values a slot may hold for new values in it to be
+	//reported.  Slots with more, such as ids or times, are skipped.  Default 20.
+	NewValueMaxCardinality int
+
+	//NewValueStable is how many values a slot must see in a row without a new one before new values
+	//in it are reported.  Default 200.
+	NewValueStable int64
+
+	//RarePercentile, when set, reports lines matching a pattern whose match count is in this lowest
+	//fraction of all patterns, so 0.01 reports matches of the rarest 1% of patterns.
+	RarePercentile float64
+
+	//RareWarmup is how many lines must be read before rare patterns are reported.  Default 10000.
+	RareWarmup int64
+
+	//RateWindow, when set, counts the matches of every pattern in windows of this length and reports
+	//windows with far more or far fewer matches than usual.
+	RateWindow time.Duration
+
+	//RateAlpha is the weight of the newest window in the moving average of matches per window.  Default 0.3.
+	RateAlpha float64
+
+	//RateSpike is how many times the average a window must reach to be reported as a spike.  Default 3.
+	RateSpike float64
+
+	//RateDrop is the fraction of the average a window must fall to to be reported as a drop.  Default 0.2.
+	RateDrop float64
+
+	//RateMinBaseline is the average matches per window a pattern needs before drops are reported,
+	//and the least a spike is measured against.  Default 5.
+	RateMinBaseline float64
+
+	//RateWarmup is how many windows a pattern must have been seen for before its rate is reported.  Default 5.
+	RateWarmup int
+
+	//MaxPatterns, when set, is the most patterns kept.  When a new pattern is learned beyond it,
+	//the pattern chosen by Eviction is forgotten.
+	MaxPatterns int
+
+	//Eviction chooses which pattern is forgotten when MaxPatterns is reached.  Default EvictLRU.
+	Eviction Eviction
+
+	//MaxUnmatched, when set, is the most unmatched lines kept.  Lines that were already reported
+	//are dropped first.  Every new line is compared to every unmatched line, so this also bounds
+	//the time taken per line.
+	MaxUnmatched int
+
+	//UnmatchedTTL, when set, drops unmatched lines that have waited longer than this.
+	//A line that was never reported is offered as timed out before it is dropped.
+	UnmatchedTTL time.Duration
+
+	//MaxVariations, when set, is the most distinct values counted for each wildcard slot.
+	//Further values are counted together in a single bucket.
+	MaxVariations int
+
+	//Candidates, when set, is the most unmatched lines a new line is compared to.  They are proposed by
+	//a MinHash index of the lines' tokens, so the time taken per line no longer grows with the number of
+	//unmatched lines.  A line may then miss an unmatched line it is similar to but shares few tokens with.
+	Candidates int
+
+	//MergeSimilarity is the fraction of fixed words two aligned patterns must keep when merged
+	//for Consolidate to merge them.  Default 0.75.
+	MergeSimilarity float64
+
+	//ConsolidateEvery, when set, runs Consolidate after every this many lines.
+	ConsolidateEvery int64
+
+	//Workers, when more than 1, is how many goroutines Run and RunWithHandler analyze lines on.
+	//The search for a matching pattern or unmatched line runs in parallel, learning from each line
+	//still happens one line at a time.
+	Workers int
+
+	//Ordered makes Workers learn from lines, and so report anomalies, in the order they were read.
+	//Without it each line is learned from as soon as it has been searched, which is faster but
+	//lets Seq and the anomalies follow that order instead.
+	Ordered bool
+
+	//Multiline, when any of its rules is set, joins continuation lines such as the frames of a stack trace
+	//onto the line that started their event, so the event is analyzed, and reported, once.
+	Multiline Multiline
+
+	//Freeze stops the model from changing while lines are analyzed, as when detecting against a model
+	//built with Train.  No pattern is learned, revised or merged: a line that does not fit a pattern as it is
+	//gets reported and is not kept to be compared with later lines.  Counts, rates and slot values are
+	//still updated.  Train learns even when Freeze is set.
+	Freeze bool
+
+	//History is how many of the latest anomalies are remembered, so MarkBenign and MarkConfirmed
+	//can be given their id.  Default 1000.
+	History int
+
+	//Rules silence or always report the lines they match, the first rule a line matches applies
+	Rules []Rule
+
+	//SequenceOrder, when set, learns which pattern follows each run of this many patterns and reports a
+	//line whose pattern seldom follows the patterns before it, such as the start of a reconnect loop.
+	//1 looks at the pattern before the line only.  Lines are compared with the lines before them from the
+	//same Record.Source, or Options.Source, so files read together are followed separately.
+	SequenceOrder int
+
+	//SequenceMinProbability is how likely a pattern must be to follow the patterns before it for the line
+	//not to be reported.  Default 0.01.
+	SequenceMinProbability float64
+
+	//SequenceWarmup is how many times a run of patterns, or a pattern waiting for SequenceTimeout, must have
+	//been seen before what follows it is reported.  Default 100.
+	SequenceWarmup int64
+
+	//SequenceTimeout, when set, learns which patterns follow each pattern within this time and reports a
+	//line whose pattern was not followed in time by a pattern that nearly always follows it.
+	SequenceTimeout time.Duration
+
+	//SequenceExpected is the fraction of matches of a pattern another pattern must have followed within
+	//SequenceTimeout to be expected after it.  Default 0.95.
+	SequenceExpected float64
+
+	//SequenceMaxOpen is the most matches of each source kept waiting for SequenceTimeout, the oldest are
+	//dropped.  Every match is added to each waiting match of its source, so this bounds the time taken per
+	//line.  Default 1000.
+	SequenceMaxOpen int
+}
+
+//DefaultOptions returns the options used by the package level Run function
+func DefaultOptions() Options {
+	return Options{
+		TokenMatchRatio:        DefaultTokenMatchRatio,
+		SimilarityThreshold:    DefaultSimilarityThreshold,
+		UnmatchedTimeout:       DefaultUnmatchedTimeout,
+		LengthSimilarity:       DefaultLengthSimilarity,
+		CreationRateGate:       DefaultCreationRateGate,
+		RateDecay:              DefaultRateDecay,
+		VertexDistance:         DefaultVertexDistance,
+		VertexPreference:       DefaultVertexPreference,
+		Clock:                  SystemClock{},
+		Tokenizer:              DefaultTokenizer{},
+		KindMinSamples:         DefaultKindMinSamples,
+		RareWarmup:             DefaultRareWarmup,
+		RateAlpha:              DefaultRateAlpha,
+		RateSpike:              DefaultRateSpike,
+		RateDrop:               DefaultRateDrop,
+		RateMinBaseline:        DefaultRateMinBaseline,
+		RateWarmup:             DefaultRateWarmup,
+		MergeSimilarity:        DefaultMergeSimilarity,
+		History:                DefaultHistory,
+		SequenceMinProbability: DefaultSequenceMinProb,
+		SequenceWarmup:         DefaultSequenceWarmup,
+		SequenceExpected:       DefaultSequenceExpected,
+		SequenceMaxOpen:        DefaultSequenceMaxOpen,
+		OutlierMinSamples:      DefaultOutlierMinSamples,
+		OutlierQuantile:        DefaultOutlierQuantile,
+		OutlierDeviations:      DefaultOutlierDeviations,
+		NewValueMaxCardinality: DefaultNewValueMaxCard,
+		NewValueStable:         DefaultNewValueStable,
+	}
+}
+
+//returns a copy of the options with every unset field replaced by its default
+func (o Options) withDefaults() Options {
+	def := DefaultOptions()
+	if o.TokenMatchRatio <= 0 {
+		o.TokenMatchRatio = def.TokenMatchRatio
+	}
+	if o.SimilarityThreshold <= 0 {
+		o.SimilarityThreshold = def.SimilarityThreshold
+	}
+	if o.UnmatchedTimeout <= 0 {
+		o.UnmatchedTimeout = def.UnmatchedTimeout
+	}
+	if o.LengthSimilarity <= 0 {
+		o.LengthSimilarity = def.LengthSimilarity
+	}
+	if o.CreationRateGate <= 0 {
+		o.CreationRateGate = def.CreationRateGate
+	}
+	if o.RateDecay <= 0 {
+		o.RateDecay = def.RateDecay
+	}
+	if o.VertexDistance <= 0 {
+		o.VertexDistance = def.VertexDistance
+	}
+	if o.VertexPreference <= 0 {
+		o.VertexPreference = def.VertexPreference
+	}
+	if o.Clock == nil {
+		o.Clock = def.Clock
+	}
+	if o.Tokenizer == nil {
+		o.Tokenizer = def.Tokenizer
+	}
+	if o.KindMinSamples <= 0 {
+		o.KindMinSamples = def.KindMinSamples
+	}
+	if o.OutlierMinSamples <= 0 {
+		o.OutlierMinSamples = def.OutlierMinSamples
+	}
+	if o.OutlierQuantile <= 0 {
+		o.OutlierQuantile = def.OutlierQuantile
+	}
+	if o.OutlierDeviations <= 0 {
+		o.OutlierDeviations = def.OutlierDeviations
+	}
+	if o.NewValueMaxCardinality <= 0 {
+		o.NewValueMaxCardinality = def.NewValueMaxCardinality
+	}
+	if o.NewValueStable <= 0 {
+		o.NewValueStable = def.NewValueStable
+	}
+	if o.RareWarmup <= 0 {
+		o.RareWarmup = def.RareWarmup
+	}
+	if o.RateAlpha <= 0 {
+		o.RateAlpha = def.RateAlpha
+	}
+	if o.RateSpike <= 0 {
+		o.RateSpike = def.RateSpike
+	}
+	if o.RateDrop <= 0 {
+		o.RateDrop = def.RateDrop
+	}
+	if o.RateMinBaseline <= 0 {
+		o.RateMinBaseline = def.RateMinBaseline
+	}
+	if o.RateWarmup <= 0 {
+		o.RateWarmup = def.RateWarmup
+	}
+	if o.MergeSimilarity <= 0 {
+		o.MergeSimilarity = def.MergeSimilarity
+	}
+	if o.SequenceMinProbability <= 0 {
+		o.SequenceMinProbability = def.SequenceMinProbability
+	}
+	if o.SequenceWarmup <= 0 {
+		o.SequenceWarmup = def.SequenceWarmup
+	}
+	if o.SequenceExpected <= 0 {
+		o.SequenceExpected = def.SequenceExpected
+	}
+	if o.SequenceMaxOpen <= 0 {
+		o.SequenceMaxOpen = def.SequenceMaxOpen
+	}
+	if o.History <= 0 {
+		o.History = def.History
+	}
+	if o.Multiline.MaxLines <= 0 {
+		o.Multiline.MaxLines = DefaultMultilineMaxLines
+	}
+	return o
+}
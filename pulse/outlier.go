@@ -0,0 +1,111 @@
+package pulse
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+//streaming statistics of the numeric values seen in a wildcard slot.  The mean and variance are kept
+//with Welford's method and the quantiles with a t-digest.
+type numericStats struct {
+	count  int64
+	mean   float64
+	m2     float64
+	digest digest
+}
+
+//returns the value of a number or duration as a float, durations in seconds
+func numericValue(value string) (float64, Kind, bool) {
+	switch kind := inferKind(value); kind {
+	case KindInt, KindFloat:
+		x, err := strconv.ParseFloat(value, 64)
+		return x, kind, err == nil
+	case KindDuration:
+		x, err := time.ParseDuration(value)
+		return x.Seconds(), kind, err == nil
+	}
+	return 0, KindUnknown, false
+}
+
+//counts a value seen in the slot in its numeric statistics, if it is a number
+func (t *token) learnNumber(value string) {
+	x, _, ok := numericValue(value)
+	if !ok {
+		return
+	}
+	if t.stats == nil {
+		t.stats = &numericStats{}
+	}
+	t.stats.add(x)
+}
+
+func (s *numericStats) add(x float64) {
+	s.count++
+	delta := x - s.mean
+	s.mean += delta / float64(s.count)
+	s.m2 += delta * (x - s.mean)
+	s.digest.add(x)
+}
+
+//returns the statistics of two slots taken together
+func mergeStats(a, b *numericStats) *numericStats {
+	if a == nil && b == nil {
+		return nil
+	}
+	m := &numericStats{}
+	for _, s := range []*numericStats{a, b} {
+		if s == nil || s.count == 0 {
+			continue
+		}
+		count := m.count + s.count
+		delta := s.mean - m.mean
+		m.mean += delta * float64(s.count) / float64(count)
+		m.m2 += s.m2 + delta*delta*float64(m.count)*float64(s.count)/float64(count)
+		m.count = count
+		m.digest.merge(&s.digest)
+	}
+	return m
+}
+
+func (s *numericStats) stdDev() float64 {
+	if s.count < 2 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.count-1))
+}
+
+//returns the range values are expected in.  It spans the quantiles Options.OutlierQuantile and
+//1-OutlierQuantile, and at least Options.OutlierDeviations standard deviations either side of the mean,
+//so that neither a narrow spread nor a long tail alone makes a value an outlier.
+func (s *numericStats) bounds(quantile, deviations float64) (float64, float64) {
+	spread := deviations * s.stdDev()
+	return math.Min(s.digest.quantile(quantile), s.mean-spread), math.Max(s.digest.quantile(1-quantile), s.mean+spread)
+}
+
+//checks a numeric value against the range its slot has learned
+func (d *Detector) checkOutlier(t *token, value string) (string, bool) {
+	if t.stats == nil || t.stats.count < int64(d.opts.OutlierMinSamples) {
+		return "", false
+	}
+	x, kind, ok := numericValue(value)
+	if !ok || (t.kind != KindInt && t.kind != KindFloat && t.kind != KindDuration) || mergeKind(t.kind, kind) != t.kind {
+		return "", false
+	}
+	lo, hi := t.stats.bounds(d.opts.OutlierQuantile, d.opts.OutlierDeviations)
+	if x >= lo && x <= hi {
+		return "", false
+	}
+	return formatNumber(t.kind, lo) + ".." + formatNumber(t.kind, hi), true
+}
+
+//formats a bound of a range the way values of the kind are written
+func formatNumber(kind Kind, x float64) string {
+	switch kind {
+	case KindInt:
+		return strconv.FormatFloat(math.Round(x), 'f', 0, 64)
+	case KindDuration:
+		return time.Duration(x * float64(time.Second)).Round(time.Microsecond).String()
+	}
+	return strconv.FormatFloat(x, 'g', 6, 64)
+}
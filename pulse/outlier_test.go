@@ -0,0 +1,47 @@
+package pulse_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestValueOutlier(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.ReportOutliers = true
+	var lines []string
+	for i := 0; i < 300; i++ {
+		lines = append(lines, fmt.Sprintf("request %d for /api/users took %dms", i, 40+i%21))
+	}
+	d := trainLines(opts, lines, &anomalies)
+
+	d.Analyze("request 300 for /api/users took 52ms")
+	d.Analyze("request 301 for /api/users took 98000ms")
+	if len(anomalies) != 1 || anomalies[0].Reason != ReasonValueOutlier {
+		t.Fatalf("Expected one value outlier, got %v", anomalies)
+	}
+	if anomalies[0].Value != "98000ms" || anomalies[0].Slot != 2 || anomalies[0].Expected == "" {
+		t.Errorf("The outlier does not name its slot, value and expected range")
+		t.Logf("Expected: 98000ms in slot 2")
+		t.Logf("Actual: %s in slot %d, expected %s", anomalies[0].Value, anomalies[0].Slot, anomalies[0].Expected)
+	}
+
+	var saved bytes.Buffer
+	if err := d.Save(&saved); err != nil {
+		t.Fatalf("Could not save model. %s", err)
+	}
+	loaded, err := LoadWithOptions(&saved, opts)
+	if err != nil {
+		t.Fatalf("Could not load model. %s", err)
+	}
+	anomalies = nil
+	loaded.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+	loaded.Analyze("request 302 for /api/users took 3ms")
+	if len(anomalies) != 1 || anomalies[0].Reason != ReasonValueOutlier {
+		t.Errorf("The learned range was not kept by Save and Load")
+		t.Logf("Actual: %v", anomalies)
+	}
+}
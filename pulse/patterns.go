@@ -0,0 +1,110 @@
+package pulse
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+//how many of the most common values of each slot Patterns returns
+const topValues = 5
+
+//PatternInfo describes a learned pattern, see Detector.Patterns
+type PatternInfo struct {
+	//ID is the id of the pattern, as in Anomaly.PatternID.  It is kept when the model is saved and loaded.
+	ID int64
+	//Source is the source or label value of the model the pattern belongs to, with Options.SplitBySource
+	//or SplitByLabel, otherwise Options.Source
+	Source string
+	//Template is the pattern with its wildcards shown as <*> or as typed placeholders such as <INT>
+	Template string
+	//Matches is how many lines the pattern has matched
+	Matches int64
+	//FirstSeen and LastSeen are the times of the line the pattern was learned from and of the last line
+	//it matched.  They are zero for patterns of models saved before they were kept.
+	FirstSeen time.Time
+	LastSeen  time.Time
+	//Slots describes each wildcard of the pattern, in order
+	Slots []SlotInfo
+}
+
+//SlotInfo describes a wildcard slot of a pattern
+type SlotInfo struct {
+	//Slot is the position of the wildcard in the template, counting from 1, as in Anomaly.Slot
+	Slot int
+	//Kind is the kind of value the slot has learned
+	Kind Kind
+	//Distinct is how many distinct values the slot has kept, see Options.MaxVariations
+	Distinct int
+	//Top is the most common values of the slot, most common first
+	Top []ValueCount
+}
+
+//ValueCount is a value of a slot and how many lines had it
+type ValueCount struct {
+	Value string
+	Count int64
+}
+
+//Patterns returns what the detector has learned, ordered by source and id.
+//With Options.SplitBySource or SplitByLabel the patterns of every model are returned.
+func (d *Detector) Patterns() []PatternInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	infos := make([]PatternInfo, 0, len(d.patterns))
+	for _, p := range d.patterns {
+		info := PatternInfo{
+			ID:        p.id,
+			Source:    d.modelKey(),
+			Template:  p.template(),
+			Matches:   p.numMatches,
+			FirstSeen: p.firstSeen,
+			LastSeen:  p.lastMatched,
+		}
+		for i, t := range p.tokens {
+			if t.variable {
+				info.Slots = append(info.Slots, t.slotInfo(p.slotNumber(i)))
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	for _, c := range d.childList() {
+		infos = append(infos, c.Patterns()...)
+	}
+	return infos
+}
+
+//dates the pattern findPattern just learned from the time of the unmatched line it was learned from
+func (d *Detector) learnedFrom(u unmatchedLog) {
+	p := d.patterns[len(d.patterns)-1]
+	if !u.dateStored.IsZero() && (p.firstSeen.IsZero() || u.dateStored.Before(p.firstSeen)) {
+		p.firstSeen = u.dateStored
+	}
+}
+
+//describes the slot, with its most common values
+func (t token) slotInfo(slot int) SlotInfo {
+	s := SlotInfo{Slot: slot, Kind: t.kind}
+	for _, v := range t.variations {
+		//values counted together once Options.MaxVariations was reached are not a value of their own
+		if v.text == otherVariation {
+			continue
+		}
+		s.Distinct++
+		//values taken from lines that had already been merged can hold wildcards of their own
+		s.Top = append(s.Top, ValueCount{strings.Replace(v.text, "!WILDCARD!", wildcardPlaceholder, -1), v.numMatches})
+	}
+	sort.SliceStable(s.Top, func(i, j int) bool { return s.Top[i].Count > s.Top[j].Count })
+	if len(s.Top) > topValues {
+		s.Top = s.Top[:topValues]
+	}
+	return s
+}
+
+//SortByMatches orders patterns from the one that matched the most lines to the one that matched the fewest
+func SortByMatches(patterns []PatternInfo) {
+	sort.SliceStable(patterns, func(i, j int) bool { return patterns[i].Matches > patterns[j].Matches })
+}
@@ -0,0 +1,110 @@
+package pulse_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestPatterns(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Tokenizer = StructuredTokenizer{}
+	d := New(opts)
+	start := time.Date(2016, 1, 23, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		d.AnalyzeRecord(Record{Line: fmt.Sprintf("link on eth%d is up after %d retries", i%3/2, i), Time: at})
+		if i%3 == 0 {
+			d.AnalyzeRecord(Record{Line: fmt.Sprintf("disk /dev/sda%d is full", i), Time: at})
+		}
+	}
+
+	patterns := d.Patterns()
+	if len(patterns) != 2 {
+		t.Fatalf("Expected 2 patterns, got %v", patterns)
+	}
+	SortByMatches(patterns)
+	p := patterns[0]
+	if p.Template != "link on <*> is up after <INT> retries" {
+		t.Errorf("Template does not match")
+		t.Logf("Actual: %s", p.Template)
+	}
+	if p.Matches != 29 || !p.FirstSeen.Equal(start) || !p.LastSeen.Equal(start.Add(29*time.Minute)) {
+		t.Errorf("Counts or times do not match")
+		t.Logf("Expected: 29 %s %s", start, start.Add(29*time.Minute))
+		t.Logf("Actual: %d %s %s", p.Matches, p.FirstSeen, p.LastSeen)
+	}
+	if len(p.Slots) != 2 || p.Slots[0].Distinct != 2 || p.Slots[0].Top[0].Value != "eth0" || p.Slots[0].Top[1] != (ValueCount{"eth1", 10}) {
+		t.Errorf("Top values do not match")
+		t.Logf("Actual: %v", p.Slots)
+	}
+	if len(p.Slots) == 2 && (p.Slots[1].Kind != KindInt || len(p.Slots[1].Top) != 5) {
+		t.Errorf("Expected the 5 most common of the retries")
+		t.Logf("Actual: %v", p.Slots[1])
+	}
+
+	var saved bytes.Buffer
+	if err := d.Save(&saved); err != nil {
+		t.Fatalf("Could not save model. %s", err)
+	}
+	loaded, err := Load(&saved)
+	if err != nil {
+		t.Fatalf("Could not load model. %s", err)
+	}
+	for _, l := range loaded.Patterns() {
+		if l.ID == p.ID && (!l.FirstSeen.Equal(p.FirstSeen) || !l.LastSeen.Equal(p.LastSeen)) {
+			t.Errorf("Times were not kept by Save and Load")
+		}
+	}
+}
+
+func TestPatternsSplitByLabel(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.Source = "syslog"
+	opts.SplitByLabel = "host"
+	opts.RateWindow = time.Minute
+	opts.RateWarmup = 3
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+	start := time.Date(2016, 1, 23, 10, 0, 0, 0, time.UTC)
+	web := map[string]string{"host": "web1"}
+	for i := 0; i < 40; i++ {
+		at := start.Add(time.Duration(i) * 10 * time.Second)
+		d.AnalyzeRecord(Record{Line: fmt.Sprintf("GET /index%d.html returned 200 in %dms", i, i), Time: at, Labels: web})
+		d.AnalyzeRecord(Record{Line: fmt.Sprintf("usb 1-%d: new high-speed USB device number %d", i, i), Time: at, Labels: map[string]string{"host": "db1"}})
+	}
+
+	patterns := d.Patterns()
+	if len(patterns) != 2 || patterns[0].Source != "db1" || patterns[1].Source != "web1" {
+		t.Errorf("Patterns are not tagged with the label value of their model")
+		for _, p := range patterns {
+			t.Logf("Actual: %s %s", p.Source, p.Template)
+		}
+	}
+
+	//a burst of requests on web1 is reported as a rate spike of its model
+	anomalies = nil
+	burst := start.Add(10 * time.Minute)
+	for i := 0; i < 60; i++ {
+		d.AnalyzeRecord(Record{Line: fmt.Sprintf("GET /index%d.html returned 200 in %dms", i, i), Time: burst, Labels: web})
+	}
+	d.AnalyzeRecord(Record{Line: "GET /index0.html returned 200 in 0ms", Time: burst.Add(time.Minute), Labels: web})
+	var spike *Anomaly
+	for i := range anomalies {
+		if anomalies[i].Reason == ReasonRateSpike {
+			spike = &anomalies[i]
+		}
+	}
+	if spike == nil {
+		t.Fatalf("Expected a rate spike, got %v", anomalies)
+	}
+	if spike.Source != "syslog" || spike.Labels["host"] != "web1" {
+		t.Errorf("The rate spike does not carry the label of its model")
+		t.Logf("Expected: syslog map[host:web1]")
+		t.Logf("Actual: %s %v", spike.Source, spike.Labels)
+	}
+}
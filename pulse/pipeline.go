@@ -0,0 +1,141 @@
+package pulse
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+//a line handed to a worker.  With Options.Ordered a worker waits for prev to close before it
+//commits the line, and closes done once it has.
+type job struct {
+	detector *Detector
+	event    []Record
+	prev     <-chan struct{}
+	done     chan struct{}
+}
+
+//reads lines from in until it is closed or ctx is done, analyzing them on Options.Workers goroutines.
+//Each worker looks the pattern up under the read lock, compares the line with the unmatched lines
+//without a lock, and only takes the write lock to commit.
+func (d *Detector) runWorkers(ctx context.Context, in <-chan Record) {
+	jobs := make(chan job, d.opts.Workers)
+	var wg sync.WaitGroup
+	for i := 0; i < d.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				work(j)
+			}
+		}()
+	}
+
+	var prev chan struct{}
+	send := func(c *Detector, event []Record) {
+		j := job{detector: c, event: event}
+		if d.opts.Ordered {
+			j.prev = prev
+			j.done = make(chan struct{})
+			prev = j.done
+		}
+		//jobs is read in order, so the worker holding the job before this one is already running
+		jobs <- j
+	}
+read:
+	for {
+		select {
+		case r, ok := <-in:
+			if !ok {
+				break read
+			}
+			//events are assembled here, in the order lines were read, and the last one is left for Flush
+			c := d.detectorFor(r)
+			if event := c.assemble(r); event != nil {
+				send(c, event)
+			}
+		case <-d.idle():
+			for _, c := range d.detectors() {
+				if event := c.expireEvent(); event != nil {
+					send(c, event)
+				}
+			}
+		case <-ctx.Done():
+			break read
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+//the unmatched lines as of the last commit.  A view is replaced, never changed, so workers compare lines
+//with it without holding the lock, and only the lines stored since are compared under it.
+type unmatchedView struct {
+	lines []unmatchedBody
+	//the slot of the last line stored when the view was taken
+	last int64
+}
+
+type unmatchedBody struct {
+	slot int64
+	body string
+}
+
+//replaces the view if unmatched lines have been stored or removed since it was taken, the caller must
+//hold the lock.  Every stored line takes a new slot, so if none was stored any change removed lines.
+func (d *Detector) publishUnmatched() {
+	if v := d.view; v != nil && v.last == d.unmatchedSlots && len(v.lines) == len(d.unmatched) {
+		return
+	}
+	v := &unmatchedView{lines: make([]unmatchedBody, len(d.unmatched)), last: d.unmatchedSlots}
+	for i := range d.unmatched {
+		v.lines[i] = unmatchedBody{d.unmatched[i].slot, d.unmatched[i].body}
+	}
+	d.view = v
+}
+
+//returns the slot of the line in the view most similar to body and how similar it is, slot 0 if none is
+//similar at all.  With only set, only the lines with the proposed slots are compared.
+func (v *unmatchedView) closest(body string, proposed []int64, only bool) (int64, float64) {
+	var slot int64
+	maxScore := 0.0
+	compare := func(u unmatchedBody) {
+		if score := similarity(body, u.body); score > maxScore {
+			maxScore = score
+			slot = u.slot
+		}
+	}
+	if !only {
+		for _, u := range v.lines {
+			compare(u)
+		}
+		return slot, maxScore
+	}
+	for _, p := range proposed {
+		i := sort.Search(len(v.lines), func(i int) bool { return v.lines[i].slot >= p })
+		if i < len(v.lines) && v.lines[i].slot == p {
+			compare(v.lines[i])
+		}
+	}
+	return slot, maxScore
+}
+
+//analyzes a job on the detector that learns it
+func work(j job) {
+	d := j.detector
+	w := d.prepare(j.event)
+	d.mu.RLock()
+	d.read(w)
+	d.mu.RUnlock()
+	w.compareUnmatched()
+
+	if j.prev != nil {
+		<-j.prev
+	}
+	d.mu.Lock()
+	d.commit(w)
+	d.mu.Unlock()
+	if j.done != nil {
+		close(j.done)
+	}
+}
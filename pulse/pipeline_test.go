@@ -0,0 +1,94 @@
+package pulse_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+//reads up to n lines of the kernel log bundled with LogPulse
+func kernLog(tb testing.TB, n int) []string {
+	f, err := os.Open("../LogPulse/kern.log.2.gz")
+	if err != nil {
+		tb.Skipf("Could not open kern.log.2.gz. %s", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		tb.Fatalf("Could not read kern.log.2.gz. %s", err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() && len(lines) < n {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+//runs lines through a new detector and returns what it reported
+func runLines(opts Options, lines []string) []Anomaly {
+	var anomalies []Anomaly
+	in := make(chan string)
+	d := New(opts)
+	d.RunWithHandler(context.Background(), in, func(a Anomaly) { anomalies = append(anomalies, a) })
+	for _, line := range lines {
+		in <- line
+	}
+	close(in)
+	d.Wait()
+	return anomalies
+}
+
+func kernOptions() Options {
+	opts := DefaultOptions()
+	opts.StripHeaders = true
+	opts.HeaderTime = true
+	return opts
+}
+
+func TestOrderedWorkersMatchSequential(t *testing.T) {
+	lines := kernLog(t, 500)
+	expected := runLines(kernOptions(), lines)
+
+	opts := kernOptions()
+	opts.Workers = 4
+	opts.Ordered = true
+	actual := runLines(opts, lines)
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Ordered workers did not report the same anomalies as one goroutine")
+		t.Logf("Expected: %d anomalies", len(expected))
+		t.Logf("Actual: %d anomalies", len(actual))
+	}
+}
+
+func benchmarkKernLog(b *testing.B, opts Options) {
+	lines := kernLog(b, 2000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runLines(opts, lines)
+	}
+}
+
+func BenchmarkKernLog(b *testing.B) {
+	benchmarkKernLog(b, kernOptions())
+}
+
+func BenchmarkKernLogWorkers4(b *testing.B) {
+	opts := kernOptions()
+	opts.Workers = 4
+	benchmarkKernLog(b, opts)
+}
+
+func BenchmarkKernLogWorkers4Ordered(b *testing.B) {
+	opts := kernOptions()
+	opts.Workers = 4
+	opts.Ordered = true
+	benchmarkKernLog(b, opts)
+}
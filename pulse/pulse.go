@@ -1,9 +1,11 @@
 package pulse
 
 import (
-	"fmt"
+	"context"
 	"math"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 	"unicode"
 )
@@ -12,8 +14,19 @@ type outputFunc func(string)
 
 type unmatchedLog struct {
 	line       string
+	lines      []string
+	body       string
+	header     Header
 	dateStored time.Time
 	reported   bool
+	seq        int64
+	pattern    string
+	patternID  int64
+	score      float64
+	slot       int64
+	bands      []uint64
+	source     string
+	labels     map[string]string
 }
 
 type revision struct {
@@ -33,11 +46,23 @@ type token struct {
 	variable   bool
 	required   bool
 	variations []variation
+	kind       Kind
+	samples    int64
+	stats      *numericStats
+	//how many values the slot has seen since it last learned a new one, see Options.ReportNewValues
+	stable int64
 }
 
 type pattern struct {
+	id         int64
 	tokens     []token
 	numMatches int64
+	lastSeen   int64
+	rate       patternRate
+	pin        pin
+	//the times of the lines the pattern was learned from and last matched
+	firstSeen   time.Time
+	lastMatched time.Time
 }
 
 type vertex struct {
@@ -53,19 +78,49 @@ type vertexDistance struct {
 
 type distArray []vertexDistance
 
-//Channel to receive log data from consuming application
-var input <-chan string
-var report outputFunc
-var patternCreationRate float64
-var patternCreationRateIncreasing bool
-var inputsSinceLastNewPattern int64
-var lastPatternCount int
-var unmatched []unmatchedLog
-var patterns []pattern
-
-const tokenMapSize int = 2048
-
-var tokenMap [tokenMapSize]map[*pattern]bool
+//Detector holds the patterns learned from a single stream of input.
+//Each Detector is independent, so one process can model several log streams at once.
+type Detector struct {
+	mu                            sync.RWMutex
+	opts                          Options
+	handler                       Handler
+	done                          chan struct{}
+	seq                           int64
+	patternCreationRate           float64
+	patternCreationRateIncreasing bool
+	inputsSinceLastNewPattern     int64
+	lastPatternCount              int
+	logTime                       time.Time
+	lastDecay                     time.Time
+	rareCutoff                    int64
+	windowStart                   time.Time
+	rareRefreshAt                 int64
+	evictedPatterns               int64
+	droppedUnmatched              int64
+	unmatched                     []unmatchedLog
+	patterns                      []*pattern
+	nextID                        int64
+	generation                    int64
+	unmatchedSlots                int64
+	index                         tokenIndex
+	lsh                           *lshIndex
+	byID                          map[int64]*pattern
+	events                        *assembler
+	training                      bool
+	trained                       bool
+	nextAnomalyID                 int64
+	history                       []Anomaly
+	historyNext                   int
+	templates                     [][]string
+	ruleHits                      []int64
+	lineSent                      int64
+	sequence                      *sequenceModel
+	view                          *unmatchedView
+	parent                        *Detector
+	children                      map[string]*Detector
+	key                           string
+	lineAt                        time.Time
+}
 
 func (s distArray) Len() int           { return len(s) }
 func (s distArray) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -78,11 +133,21 @@ func max(a, b int) int {
 	return a
 }
 
-//sets initial state of the token map, used to lookup existing patterns
-func initTokenMap() {
-	for i := 0; i < tokenMapSize; i++ {
-		tokenMap[i] = make(map[*pattern]bool)
+//New returns a Detector with an empty model
+func New(opts Options) *Detector {
+	d := &Detector{opts: opts.withDefaults(), index: make(tokenIndex), byID: make(map[int64]*pattern)}
+	if d.opts.Candidates > 0 {
+		d.lsh = newLSHIndex()
+	}
+	if d.opts.Multiline.enabled() {
+		d.events = &assembler{rules: d.opts.Multiline}
 	}
+	if d.opts.SequenceOrder > 0 || d.opts.SequenceTimeout > 0 {
+		d.sequence = newSequenceModel()
+	}
+	d.templates = ruleTemplates(d.opts.Rules, d.opts.Tokenizer)
+	d.ruleHits = make([]int64, len(d.opts.Rules))
+	return d
 }
 
 //converts a string into a slice of strings.  symbols and contiguous strings of any other type
@@ -135,7 +200,7 @@ func addUpdateVertex(newValue vertex, list []vertex) []vertex {
 //locates the vertex in the list that is closest to the supplied vertex,
 //however some preferential treatment is given to vertices that begin a
 //longer sequence of shared substrings in the inputs being compared
-func getNextVertex(value vertex, vertices []vertex) (bool, vertex) {
+func getNextVertex(value vertex, vertices []vertex, preference int) (bool, vertex) {
 	x := value.x
 	y := value.y
 
@@ -164,7 +229,7 @@ func getNextVertex(value vertex, vertices []vertex) (bool, vertex) {
 	if len(distances) > 1 {
 		nextMin = distances[1]
 		var difference = nextMin.distance - minDistance.distance
-		if difference <= 3 && vertices[nextMin.index].startsSequenceOfLength > nextVertex.startsSequenceOfLength {
+		if difference <= preference && vertices[nextMin.index].startsSequenceOfLength > nextVertex.startsSequenceOfLength {
 			nextVertex = vertices[nextMin.index]
 		}
 	}
@@ -183,76 +248,10 @@ func removeVertexFromList(val vertex, vertices []vertex) []vertex {
 	return vertices
 }
 
-//returns sorted list of tokens in pattern, sorted in the order they appear in both strings
-func analyzeMatrix(matrix [][]int, vertices []vertex) (bool, []vertex) {
-	//start with {0, 0}
-	var tokens []vertex
-	if matrix[0][0] > 0 {
-		tokens = append(tokens, vertices[0])
-		vertices = removeVertexFromList(vertices[0], vertices)
-	}
-	var start = vertex{0, 0, 0}
-	var foundNextPoint, nextPoint = getNextVertex(start, vertices)
-	for foundNextPoint {
-		tokens = append(tokens, nextPoint)
-		vertices = removeVertexFromList(nextPoint, vertices)
-		foundNextPoint, nextPoint = getNextVertex(nextPoint, vertices)
-	}
-	return float64(len(tokens)) > float64(len(matrix[0])/2), tokens
-}
-
-//using a list of words and a pointer to a pattern, update the
-//token map so that the words can be used to later locate the pattern
-func updateTokenMap(words []token, ref *pattern) {
-	for i := range words {
-		if words[i].variable {
-			continue
-		}
-
-		chars := []rune(words[i].word)
-		sum := 0
-		for j := range chars {
-			value := int(chars[j])
-			sum += value
-		}
-
-		sum = sum % tokenMapSize
-
-		var pm = tokenMap[sum]
-		pm[ref] = true
-	}
-}
-
-//returns all patterns that a particular word is part of, using the token map
-func patternsFromToken(word string) []*pattern {
-	chars := []rune(word)
-	sum := 0
-	for i := range chars {
-		value := int(chars[i])
-		sum += value
-	}
-
-	sum = sum % tokenMapSize
-	var pm = tokenMap[sum]
-	keys := make([]*pattern, 0, len(pm))
-	for k := range pm {
-		if pm[k] == true {
-			keys = append(keys, k)
-		}
-	}
-
-	return keys
-}
-
-//match a pattern against a new input, revising the pattern under certain circumstances
-func matchPattern(pat pattern, longTokens []string, input string) bool {
-	foundPattern := false
+//builds the matrix of shared tokens between two inputs, and the vertices where they are shared.
+//each vertex records how long a run of shared tokens it starts.
+func buildMatrix(shortTokens []string, longTokens []string) ([][]int, []vertex) {
 	var vertices []vertex
-	var shortTokens []string
-	for i := range pat.tokens {
-		shortTokens = append(shortTokens, pat.tokens[i].word)
-	}
-
 	matrix := make([][]int, len(shortTokens))
 	for i := range shortTokens {
 		matrix[i] = make([]int, len(longTokens))
@@ -277,8 +276,38 @@ func matchPattern(pat pattern, longTokens []string, input string) bool {
 			matrix[i][j] = matches
 		}
 	}
+	return matrix, vertices
+}
+
+//returns sorted list of tokens in pattern, sorted in the order they appear in both strings
+func analyzeMatrix(matrix [][]int, vertices []vertex, preference int) (bool, []vertex) {
+	//start with {0, 0}
+	var tokens []vertex
+	if matrix[0][0] > 0 {
+		tokens = append(tokens, vertices[0])
+		vertices = removeVertexFromList(vertices[0], vertices)
+	}
+	var start = vertex{0, 0, 0}
+	var foundNextPoint, nextPoint = getNextVertex(start, vertices, preference)
+	for foundNextPoint {
+		tokens = append(tokens, nextPoint)
+		vertices = removeVertexFromList(nextPoint, vertices)
+		foundNextPoint, nextPoint = getNextVertex(nextPoint, vertices, preference)
+	}
+	return float64(len(tokens)) > float64(len(matrix[0])/2), tokens
+}
 
-	foundPattern, vertices = analyzeMatrix(matrix, vertices)
+//aligns a new input with a pattern without changing the pattern.  If they match, the tokens the pattern
+//should be revised to are returned as well, or nil when the input is longer but close enough in length.
+func (d *Detector) alignPattern(pat *pattern, longTokens []string) (bool, []token) {
+	var shortTokens []string
+	for i := range pat.tokens {
+		shortTokens = append(shortTokens, pat.tokens[i].word)
+	}
+
+	matrix, vertices := buildMatrix(shortTokens, longTokens)
+
+	foundPattern, vertices := analyzeMatrix(matrix, vertices, d.opts.VertexPreference)
 	var newPattern pattern
 	if foundPattern {
 		lastPoint := vertex{-1, -1, 0}
@@ -286,10 +315,10 @@ func matchPattern(pat pattern, longTokens []string, input string) bool {
 			var skippedBeginning = i == 0 && vertices[i].x != 0 && vertices[i].y != 0
 			var vertex = vertices[i]
 			var distance = (vertex.x - lastPoint.x) + (vertex.y - lastPoint.y)
-			if distance <= 2 && !skippedBeginning {
+			if distance <= d.opts.VertexDistance && !skippedBeginning {
 				lastPoint = vertex
 				text := shortTokens[lastPoint.x]
-				newPattern.tokens = append(newPattern.tokens, token{text, false, true, nil})
+				newPattern.tokens = append(newPattern.tokens, token{word: text, required: true})
 			} else {
 				xDiff := vertex.x - lastPoint.x
 				yDiff := vertex.y - lastPoint.y
@@ -322,91 +351,82 @@ func matchPattern(pat pattern, longTokens []string, input string) bool {
 				lastPoint = vertex
 				text := shortTokens[lastPoint.x]
 				//add wildcard token to sequence
-				newPattern.tokens = append(newPattern.tokens, token{"!WILDCARD!", true, len(variableText) > 1, variableText})
+				newPattern.tokens = append(newPattern.tokens, newWildcard(variableText))
 				//add static token to sequence
-				newPattern.tokens = append(newPattern.tokens, token{text, false, true, nil})
+				newPattern.tokens = append(newPattern.tokens, token{word: text, required: true})
 			}
 		}
 
+		if t, ok := trailingWildcard(shortTokens, longTokens, lastPoint); ok {
+			newPattern.tokens = append(newPattern.tokens, t)
+		}
+
 		if len(newPattern.tokens) <= len(pat.tokens) {
-			for i := range newPattern.tokens {
-				var originalToken = pat.tokens[i]
-				var newToken = newPattern.tokens[i]
-				var newText string
-				if newToken.variable && len(newToken.variations) == 1 {
-					newText = newToken.variations[0].text
-				}
+			return true, newPattern.tokens
+		}
 
-				if originalToken.variable && newToken.variable {
-					accountedForNewValue := false
-					for j := range originalToken.variations {
-						if originalToken.variations[j].text == newText {
-							originalToken.variations[j].numMatches++
-							accountedForNewValue = true
-						}
-					}
-					if !accountedForNewValue {
-						originalToken.variations = append(originalToken.variations, variation{newText, 1})
-					}
-				} else if newToken.variable && !originalToken.variable {
-					originalToken.word = "!WILDCARD!"
-					originalToken.variable = true
-					for j := range newToken.variations {
-						originalToken.variations = append(originalToken.variations, variation{newToken.variations[j].text, 1})
-					}
-				}
+		//determine how close the patterns are
+		var diff = math.Abs(float64(len(pat.tokens)) - float64(len(newPattern.tokens)))
+		var maxLength = float64(max(len(pat.tokens), len(newPattern.tokens)))
+		if ((maxLength - diff) / maxLength) >= d.opts.LengthSimilarity {
+			return true, nil
+		}
 
-				pat.tokens[i] = originalToken
-				pat.numMatches++
-			}
-		} else {
-			//determine how close the patterns are
-			var diff = math.Abs(float64(len(pat.tokens)) - float64(len(newPattern.tokens)))
-			var maxLength = float64(max(len(pat.tokens), len(newPattern.tokens)))
-			if ((maxLength - diff) / maxLength) >= 0.90 {
-				return true
-			}
+		//a match was made above a certain threshold between the pattern and the input, but the length of tokens is too far off
+		return false, nil
+	}
+	return false, nil
+}
 
-			//a match was made above a certain threshold between the pattern and the input, but the length of tokens is too far off
-			return false
+//revises a pattern with the tokens alignPattern returned for a matching input.
+//the values the input has in wildcard slots the pattern already had are returned to be observed.
+func (d *Detector) matchPattern(pat *pattern, newTokens []token) []slotValue {
+	var values []slotValue
+	var replaced []string
+	if newTokens == nil {
+		return nil
+	}
+
+	for i := range newTokens {
+		var originalToken = pat.tokens[i]
+		var newToken = newTokens[i]
+		var newText string
+		if newToken.variable && len(newToken.variations) == 1 {
+			newText = newToken.variations[0].text
 		}
 
-		return true
+		if originalToken.variable && newToken.variable {
+			values = append(values, slotValue{i, newText})
+		} else if newToken.variable && !originalToken.variable {
+			replaced = append(replaced, originalToken.word)
+			originalToken.word = "!WILDCARD!"
+			originalToken.variable = true
+			for j := range newToken.variations {
+				originalToken.addVariation(newToken.variations[j].text, d.opts.MaxVariations)
+				originalToken.learnKind(newToken.variations[j].text)
+				originalToken.learnNumber(newToken.variations[j].text)
+			}
+		}
+
+		pat.tokens[i] = originalToken
 	}
-	return false
+	//words that became wildcards no longer lead to the pattern, and other lines may now align differently
+	for _, word := range replaced {
+		d.unindexWord(word, pat)
+	}
+	if len(replaced) > 0 {
+		d.generation++
+	}
+	pat.numMatches++
+	return values
 }
 
 //looks for a pattern between two input strings, and learns the new pattern if
 //a certain threshold value is reached when the matrix is analyzed.
-func findPattern(shortTokens []string, longTokens []string) bool {
-	foundPattern := false
-	var vertices []vertex
-	matrix := make([][]int, len(shortTokens))
-	for i := range shortTokens {
-		matrix[i] = make([]int, len(longTokens))
-		for j := range matrix[i] {
-			var matches = 0
-			if shortTokens[i] == longTokens[j] {
-				matches++
-				vertices = addUpdateVertex(vertex{i, j, matches}, vertices)
-				var prevRow = j - 1
-				var prevCol = i - 1
-				for prevRow > 0 && prevCol > 0 {
-					if shortTokens[prevCol] == longTokens[prevRow] {
-						matches++
-						vertices = addUpdateVertex(vertex{prevCol, prevRow, matches}, vertices)
-						prevRow--
-						prevCol--
-					} else {
-						break
-					}
-				}
-			}
-			matrix[i][j] = matches
-		}
-	}
+func (d *Detector) findPattern(shortTokens []string, longTokens []string) bool {
+	matrix, vertices := buildMatrix(shortTokens, longTokens)
 
-	foundPattern, vertices = analyzeMatrix(matrix, vertices)
+	foundPattern, vertices := analyzeMatrix(matrix, vertices, d.opts.VertexPreference)
 	if foundPattern {
 		var p pattern
 
@@ -415,10 +435,10 @@ func findPattern(shortTokens []string, longTokens []string) bool {
 			var skippedBeginning = i == 0 && vertices[i].x != 0 && vertices[i].y != 0
 			var vertex = vertices[i]
 			var distance = (vertex.x - lastPoint.x) + (vertex.y - lastPoint.y)
-			if distance <= 2 && !skippedBeginning {
+			if distance <= d.opts.VertexDistance && !skippedBeginning {
 				lastPoint = vertex
 				text := shortTokens[lastPoint.x]
-				p.tokens = append(p.tokens, token{text, false, true, nil})
+				p.tokens = append(p.tokens, token{word: text, required: true})
 			} else {
 				xDiff := vertex.x - lastPoint.x
 				yDiff := vertex.y - lastPoint.y
@@ -449,26 +469,32 @@ func findPattern(shortTokens []string, longTokens []string) bool {
 				lastPoint = vertex
 				text := shortTokens[lastPoint.x]
 				//add wildcard token to sequence
-				p.tokens = append(p.tokens, token{"!WILDCARD!", true, len(variableText) > 1, variableText})
+				p.tokens = append(p.tokens, newWildcard(variableText))
 				//add static token to sequence
-				p.tokens = append(p.tokens, token{text, false, true, nil})
+				p.tokens = append(p.tokens, token{word: text, required: true})
 			}
 		}
 
-		p.numMatches = 1
-		patterns = append(patterns, p)
-
-		var reference = &p
-		updateTokenMap(p.tokens, reference)
-
-		var numPatterns = len(patterns)
-		var rate = 1.0 / float64(inputsSinceLastNewPattern)
-		var newAvgRate = ((float64(numPatterns) * patternCreationRate) + rate) / float64(numPatterns+1)
-		patternCreationRateIncreasing = newAvgRate > patternCreationRate
-		patternCreationRate = newAvgRate
+		if t, ok := trailingWildcard(shortTokens, longTokens, lastPoint); ok {
+			p.tokens = append(p.tokens, t)
+		}
 
-		inputsSinceLastNewPattern = 0
-		lastPatternCount = numPatterns
+		p.numMatches = 1
+		p.lastSeen = d.seq
+		p.firstSeen, p.lastMatched = d.lineAt, d.lineAt
+		d.patterns = append(d.patterns, &p)
+		d.indexPattern(&p)
+		d.generation++
+		d.evictPatterns(&p)
+
+		var numPatterns = len(d.patterns)
+		var rate = 1.0 / float64(d.inputsSinceLastNewPattern)
+		var newAvgRate = ((float64(numPatterns) * d.patternCreationRate) + rate) / float64(numPatterns+1)
+		d.patternCreationRateIncreasing = newAvgRate > d.patternCreationRate
+		d.patternCreationRate = newAvgRate
+
+		d.inputsSinceLastNewPattern = 0
+		d.lastPatternCount = numPatterns
 	}
 	return foundPattern
 }
@@ -492,84 +518,347 @@ func indexOfWordInVariations(value string, words []variation) int {
 	return -1
 }
 
-func reportAnomaly(line string) {
-	fmt.Printf("\nPattern count: %v\n", len(patterns))
+//sends the anomaly to the handler unless the model is still learning, returns true if it was sent.
+//Lines read by Train are known to be good, so they count as sent without being reported.
+func (d *Detector) reportAnomaly(a Anomaly) bool {
+	if d.training {
+		return true
+	}
+	if d.trained || ((!d.patternCreationRateIncreasing || d.patternCreationRate <= d.opts.CreationRateGate) && (len(d.patterns) != 0)) {
+		d.send(a)
+		return true
+	}
+	return false
+}
 
-	if (!patternCreationRateIncreasing || patternCreationRate <= 0.20) && (len(patterns) != 0) {
-		fmt.Printf("\nReporting anomaly...%v\n", line)
-		report(line)
+//rebuilds the anomaly for a line that has been waiting in the unmatched list
+func (d *Detector) unmatchedAnomaly(u unmatchedLog, reason Reason) Anomaly {
+	return Anomaly{
+		Line:      u.line,
+		Lines:     u.lines,
+		Header:    u.header,
+		Time:      u.dateStored,
+		Seq:       u.seq,
+		Source:    u.source,
+		Labels:    u.labels,
+		Pattern:   u.pattern,
+		PatternID: u.patternID,
+		Score:     u.score,
+		Reason:    reason,
 	}
 }
 
-func analyze(line string) {
-	index := -1
-	maxScore := 0.0
-	patternFound := false
-	inputsSinceLastNewPattern++
+//a line on its way through the detector.  prepare fills in the line itself, read finds what it is
+//closest to while the model is only read, and commit learns from it once the model may be changed.
+type lineWork struct {
+	line string
+	//every line of the event when Options.Multiline joined more than one, line first
+	lines  []string
+	body   string
+	header Header
+	tokens []string
+	//the source, labels and time of the Record the line came in
+	source string
+	labels map[string]string
+	at     time.Time
+	//hashes of the line's MinHash bands, only set with Options.Candidates
+	bands []uint64
+
+	//the model generation read ran against, commit runs read again if the model has changed since
+	generation     int64
+	candidate      *pattern
+	tokensInCommon int
+	aligned        bool
+	alignedTokens  []token
+	//the unmatched lines read took to compare the line with, and the slots of those Options.Candidates
+	//proposed.  The view is nil if the line matched a pattern when read ran.
+	view     *unmatchedView
+	proposed []int64
+	//slot and similarity of the closest unmatched line, slot 0 if none was close
+	closest      int64
+	closestScore float64
+	//unmatched lines stored after this slot had not been compared when read ran
+	scanned int64
+}
+
+func (d *Detector) analyze(event []Record) {
+	w := d.prepare(event)
+	d.read(w)
+	w.compareUnmatched()
+	d.commit(w)
+}
 
-	if len(patterns) == lastPatternCount {
-		patternCreationRate = patternCreationRate * 0.99
+//patterns are learned from the message body, the header is kept as metadata.
+//only the first line of an event is learned from, the rest is carried along to be reported.
+//prepare does not touch the model, so it needs no lock.
+func (d *Detector) prepare(event []Record) *lineWork {
+	line := event[0].Line
+	w := &lineWork{line: line, body: line, header: Header{Priority: -1}, source: event[0].Source, labels: event[0].Labels, at: event[0].Time}
+	if w.source == "" {
+		w.source = d.opts.Source
+	}
+	if len(event) > 1 {
+		for _, r := range event {
+			w.lines = append(w.lines, r.Line)
+		}
 	}
+	if d.opts.StripHeaders {
+		w.header, w.body = ParseHeader(line)
+	}
+	w.tokens = d.opts.Tokenizer.Tokenize(w.body)
+	if d.opts.Candidates > 0 {
+		w.bands = lshBands(w.tokens)
+	}
+	return w
+}
 
-	//search for existing pattern using token map
-	var tokenMatches = make(map[*pattern]int)
-	var lineTokens = getTokens(line)
-	for i := range lineTokens {
-		var patterns = patternsFromToken(lineTokens[i])
-		for j := range patterns {
-			var p = patterns[j]
-			tokenMatches[p] = tokenMatches[p] + 1
+//finds the likeliest pattern for the line and, if it matches none, takes the unmatched lines to compare
+//it with.  It only reads the model, so the caller needs no more than a read lock.
+func (d *Detector) read(w *lineWork) {
+	d.readPattern(w)
+	d.takeUnmatched(w)
+}
+
+//finds the likeliest pattern for the line, the caller must hold the read lock
+func (d *Detector) readPattern(w *lineWork) {
+	w.generation = d.generation
+	w.aligned, w.alignedTokens = false, nil
+
+	//search for existing pattern using the index
+	w.candidate, w.tokensInCommon = d.likeliestPattern(w.tokens)
+	if w.candidate != nil && w.overlap() >= d.opts.TokenMatchRatio {
+		w.aligned, w.alignedTokens = d.alignPattern(w.candidate, w.tokens)
+		//a frozen pattern only matches lines that fit it as it is
+		if w.aligned && d.frozen() && revises(w.candidate, w.alignedTokens) {
+			w.aligned, w.alignedTokens = false, nil
 		}
 	}
 
-	var mostLikelyPattern *pattern
-	var tokensInCommon int
+}
+
+//takes the view of the unmatched lines the line is compared with, unless it matched a pattern or has
+//already taken one.  A frozen model learns no new patterns, so it need not look for a similar line.
+func (d *Detector) takeUnmatched(w *lineWork) {
+	if w.aligned || w.view != nil || d.frozen() {
+		return
+	}
+	w.view = d.view
+	if w.view == nil {
+		w.view = &unmatchedView{}
+	}
+	w.scanned = w.view.last
+	if d.lsh != nil {
+		w.proposed = d.candidates(w.bands, 0)
+	}
+}
+
+//compares the line with the unmatched lines read took.  The view is never changed, so this, the slow
+//part of reading a line, needs no lock.
+func (w *lineWork) compareUnmatched() {
+	if w.view != nil {
+		w.closest, w.closestScore = w.view.closest(w.body, w.proposed, w.proposed != nil)
+	}
+}
+
+//returns the fraction of the line's tokens found in the likeliest pattern
+func (w *lineWork) overlap() float64 {
+	return float64(w.tokensInCommon) / float64(len(w.tokens))
+}
 
-	for k := range tokenMatches {
-		if tokenMatches[k] > tokensInCommon {
-			tokensInCommon = tokenMatches[k]
-			mostLikelyPattern = k
+//learns from the line and reports it if it is an anomaly, the caller must hold the lock
+func (d *Detector) commit(w *lineWork) {
+	defer d.publishUnmatched()
+	//the pattern is looked up again if the patterns changed, the unmatched lines stored since read ran
+	//are compared by closestIndex
+	if w.generation != d.generation {
+		d.readPattern(w)
+		if w.view == nil {
+			d.takeUnmatched(w)
+			w.compareUnmatched()
 		}
 	}
+	patternFound := false
+	d.inputsSinceLastNewPattern++
+	d.seq++
 
-	if float64(tokensInCommon)/float64(len(lineTokens)) >= 0.5 {
-		patternFound = matchPattern(*mostLikelyPattern, lineTokens, line)
+	anomaly := Anomaly{Line: w.line, Lines: w.lines, Header: w.header, Time: d.lineTime(w), Seq: d.seq, Source: w.source, Labels: w.labels, Reason: ReasonNeverMatched}
+	d.lineAt = anomaly.Time
+	//alert rules report the line whatever was learned from it
+	defer d.alertRule(&anomaly)
+
+	if len(d.patterns) == d.lastPatternCount {
+		d.decayCreationRate(anomaly.Time)
+	}
+	d.advanceWindows(anomaly.Time)
+	d.closeOccurrences(anomaly.Time)
+	d.expireUnmatched(anomaly.Time)
+
+	if w.candidate != nil {
+		anomaly.Pattern = w.candidate.template()
+		anomaly.PatternID = w.candidate.id
+		anomaly.Score = w.overlap()
+	}
+
+	if w.aligned {
+		patternFound = true
+		d.matched(w.candidate, d.matchPattern(w.candidate, w.alignedTokens), anomaly)
 	}
 
 	//if no pattern found, compare to unmatched lines, see if a new pattern can be detected
 	if !patternFound {
-		for i := range unmatched {
-			var compare = unmatched[i].line
-			var distance = ld(line, compare)
-			var timeUnmatched = time.Since(unmatched[i].dateStored).Seconds()
-			if timeUnmatched > 30.0 && !unmatched[i].reported {
-				reportAnomaly(unmatched[i].line)
-				unmatched[i].reported = true
-			}
-			var maxLength = max(len(line), len(compare))
-			var score = float64(maxLength-distance) / float64(maxLength)
-			if score > maxScore {
-				maxScore = score
-				index = i
+		for i := range d.unmatched {
+			var timeUnmatched = anomaly.Time.Sub(d.unmatched[i].dateStored)
+			if timeUnmatched > d.opts.UnmatchedTimeout && !d.unmatched[i].reported {
+				d.unmatched[i].reported = d.reportAnomaly(d.unmatchedAnomaly(d.unmatched[i], ReasonTimedOut))
 			}
 		}
 
-		if maxScore >= 0.5 {
-			var unmatchedTokens = getTokens(unmatched[index].line)
-			if len(lineTokens) < len(unmatchedTokens) {
-				patternFound = findPattern(lineTokens, unmatchedTokens)
+		if d.frozen() {
+			d.reportAnomaly(anomaly)
+			return
+		}
+
+		index, maxScore := d.closestIndex(w)
+		if maxScore >= d.opts.SimilarityThreshold {
+			var unmatchedTokens = d.opts.Tokenizer.Tokenize(d.unmatched[index].body)
+			if len(w.tokens) < len(unmatchedTokens) {
+				patternFound = d.findPattern(w.tokens, unmatchedTokens)
 			} else {
-				patternFound = findPattern(unmatchedTokens, lineTokens)
+				patternFound = d.findPattern(unmatchedTokens, w.tokens)
 			}
 		}
 
 		if !patternFound {
-			unmatched = append(unmatched, unmatchedLog{line, time.Now(), true})
-			reportAnomaly(line)
+			if anomaly.Pattern == "" && index >= 0 {
+				anomaly.Score = maxScore
+			}
+			var reported = d.reportAnomaly(anomaly)
+			d.unmatchedSlots++
+			var u = unmatchedLog{
+				line:       w.line,
+				lines:      w.lines,
+				body:       w.body,
+				header:     w.header,
+				dateStored: anomaly.Time,
+				reported:   reported,
+				seq:        anomaly.Seq,
+				pattern:    anomaly.Pattern,
+				patternID:  anomaly.PatternID,
+				score:      anomaly.Score,
+				slot:       d.unmatchedSlots,
+				bands:      w.bands,
+				source:     w.source,
+				labels:     w.labels,
+			}
+			d.unmatched = append(d.unmatched, u)
+			d.addCandidate(u)
+			d.trimUnmatched()
 		} else { //remove unmatched line from unmatched slice
-			unmatched = append(unmatched[:index], unmatched[index+1:]...)
+			d.learnedFrom(d.unmatched[index])
+			d.forgetCandidate(d.unmatched[index])
+			d.unmatched = append(d.unmatched[:index], d.unmatched[index+1:]...)
 		}
 	}
+
+	if d.opts.ConsolidateEvery > 0 && d.seq%d.opts.ConsolidateEvery == 0 && !d.frozen() {
+		d.consolidate()
+	}
+}
+
+//returns the slot of the unmatched line most similar to body and how similar it is, comparing only lines
+//stored after the supplied slot.  Slot 0 is returned if no line is similar at all.
+func (d *Detector) closestUnmatched(w *lineWork, after int64) (int64, float64) {
+	var slot int64
+	maxScore := 0.0
+	compare := func(u *unmatchedLog) {
+		if score := similarity(w.body, u.body); score > maxScore {
+			maxScore = score
+			slot = u.slot
+		}
+	}
+
+	//with Options.Candidates only the lines the index proposes are compared
+	if d.lsh != nil {
+		for _, candidate := range d.candidates(w.bands, after) {
+			if i := d.unmatchedIndex(candidate); i >= 0 {
+				compare(&d.unmatched[i])
+			}
+		}
+		return slot, maxScore
+	}
+	for i := range d.unmatched {
+		if d.unmatched[i].slot > after {
+			compare(&d.unmatched[i])
+		}
+	}
+	return slot, maxScore
+}
+
+//returns how similar two lines are, from 0 to 1, by their edit distance
+func similarity(a, b string) float64 {
+	var distance = ld(a, b)
+	var maxLength = max(len(a), len(b))
+	return float64(maxLength-distance) / float64(maxLength)
+}
+
+//returns the index of the unmatched line closest to the line and how similar it is, or -1.
+//only lines stored since read ran are compared again, unless the closest line read found is gone.
+func (d *Detector) closestIndex(w *lineWork) (int, float64) {
+	slot, score := d.closestUnmatched(w, w.scanned)
+	//on a tie the older line wins, as it comes first in the list
+	if w.closest != 0 && w.closestScore >= score {
+		slot, score = w.closest, w.closestScore
+	}
+	if index := d.unmatchedIndex(slot); index >= 0 || slot == 0 {
+		return index, score
+	}
+
+	slot, score = d.closestUnmatched(w, 0)
+	return d.unmatchedIndex(slot), score
+}
+
+//returns the index of the unmatched line with the supplied slot, or -1.
+//lines are stored in the order of their slots, so the list can be searched by halves.
+func (d *Detector) unmatchedIndex(slot int64) int {
+	if slot == 0 {
+		return -1
+	}
+	i := sort.Search(len(d.unmatched), func(i int) bool { return d.unmatched[i].slot >= slot })
+	if i < len(d.unmatched) && d.unmatched[i].slot == slot {
+		return i
+	}
+	return -1
+}
+
+//called for every line that matched an existing pattern, the line is reported if the match itself is unusual
+func (d *Detector) matched(p *pattern, values []slotValue, anomaly Anomaly) {
+	p.lastSeen = d.seq
+	p.lastMatched = anomaly.Time
+	anomaly.Pattern = p.template()
+	anomaly.PatternID = p.id
+	slotAnomaly, ok := d.observeSlots(p, values, anomaly)
+	sequenceAnomaly, outOfOrder := d.observeSequence(p, anomaly)
+	switch {
+	//confirmed patterns are reported even while the model is still learning
+	case p.pin == pinAlert:
+		if !d.training {
+			anomaly.Reason = ReasonConfirmed
+			d.send(anomaly)
+		}
+		return
+	case p.pin == pinBenign:
+		return
+	case ok:
+		d.reportAnomaly(slotAnomaly)
+		return
+	case outOfOrder:
+		d.reportAnomaly(sequenceAnomaly)
+		return
+	}
+	if d.isRare(p) {
+		anomaly.Reason = ReasonRarePattern
+		d.reportAnomaly(anomaly)
+	}
 }
 
 //Levenshtein distance algorithm Copied from http://rosettacode.org/wiki/Levenshtein_distance#Go
@@ -604,14 +893,81 @@ func ld(s, t string) int {
 	return d[len(s)][len(t)]
 }
 
-//Run starts the pulse package
-func Run(in <-chan string, out outputFunc) {
-	input = in
-	report = out
-	initTokenMap()
-	go func() {
-		for value := range in {
-			analyze(value)
+//Analyze runs a single line through the detector, learning from it and
+//reporting it to the handler if it is an anomaly.  With Options.Multiline the line is held until the
+//line after it shows whether its event has ended, call Flush to analyze the last event.
+func (d *Detector) Analyze(line string) {
+	d.AnalyzeRecord(Record{Line: line})
+}
+
+//Flush analyzes the event Options.Multiline is still assembling and offers every unmatched line
+//that has not been reported yet to the handler.  It is called when Run finishes so that no pending line is lost.
+func (d *Detector) Flush() {
+	for _, c := range d.detectors()[1:] {
+		c.Flush()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.events != nil {
+		if event := d.events.flush(); event != nil {
+			d.analyze(event)
+		}
+	}
+	d.flush()
+}
+
+//analyzes the events Options.Multiline is assembling that have been idle for Multiline.MaxIdle
+func (d *Detector) analyzeIdle() {
+	for _, c := range d.detectors() {
+		c.mu.Lock()
+		if event := c.expireEvent(); event != nil {
+			c.analyze(event)
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (d *Detector) flush() {
+	for i := range d.unmatched {
+		if !d.unmatched[i].reported {
+			d.unmatched[i].reported = d.reportAnomaly(d.unmatchedAnomaly(d.unmatched[i], ReasonNeverMatched))
 		}
-	}()
+	}
+}
+
+//RunWithHandler reads lines from in on a new goroutine, sending anomalies to handler.
+//It stops when in is closed or ctx is done, flushing any pending unmatched lines first.
+//Use Wait to block until it has finished.  See Options.Workers to analyze lines in parallel.
+func (d *Detector) RunWithHandler(ctx context.Context, in <-chan string, handler Handler) {
+	d.RunRecords(ctx, records(ctx, in), handler)
+}
+
+//Run reads lines from in on a new goroutine, sending the line of each anomaly to out.
+//An event of several lines joined by Options.Multiline is sent as one string, a line per line.
+func (d *Detector) Run(ctx context.Context, in <-chan string, out outputFunc) {
+	d.RunWithHandler(ctx, in, func(a Anomaly) {
+		if a.Lines != nil {
+			out(strings.Join(a.Lines, "\n"))
+			return
+		}
+		out(a.Line)
+	})
+}
+
+//Wait blocks until the last call to Run or RunWithHandler has finished and flushed
+func (d *Detector) Wait() {
+	d.mu.Lock()
+	done := d.done
+	d.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+//Run starts the pulse package using a new Detector with the default options.
+//The Detector is returned so the caller can Wait for it to finish.
+func Run(in <-chan string, out outputFunc) *Detector {
+	d := New(DefaultOptions())
+	d.Run(context.Background(), in, out)
+	return d
 }
@@ -0,0 +1,180 @@
+package pulse_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestDetectorsAreIndependent(t *testing.T) {
+	var first, second []string
+	d1 := New(DefaultOptions())
+	d2 := New(DefaultOptions())
+	d1.SetHandler(func(a Anomaly) { first = append(first, a.Line) })
+	d2.SetHandler(func(a Anomaly) { second = append(second, a.Line) })
+
+	for i := 0; i < 40; i++ {
+		d1.Analyze(fmt.Sprintf("user u%d logged in from host h%d", i, i))
+		d2.Analyze(fmt.Sprintf("disk sd%d is %d percent full", i, i))
+	}
+	p1, p2 := d1.Patterns(), d2.Patterns()
+	if len(p1) != 1 || len(p2) != 1 || p1[0].Template == p2[0].Template {
+		t.Fatalf("Expected each detector to learn only its own stream")
+	}
+
+	// each detector knows its own lines and reports the lines of the other stream
+	d1.Analyze("user u99 logged in from host h99")
+	d2.Analyze("disk sd99 is 99 percent full")
+	d1.Analyze("disk sd99 is 99 percent full")
+	d2.Analyze("user u99 logged in from host h99")
+	if len(first) != 1 || first[0] != "disk sd99 is 99 percent full" {
+		t.Errorf("First detector reports do not match")
+		t.Logf("Actual: %v", first)
+	}
+	if len(second) != 1 || second[0] != "user u99 logged in from host h99" {
+		t.Errorf("Second detector reports do not match")
+		t.Logf("Actual: %v", second)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	d := New(DefaultOptions())
+	d.Analyze("user alice logged in from host one")
+	d.Analyze("user bob logged in from host two")
+	d.Analyze("kernel: eth0 link is down")
+
+	var saved bytes.Buffer
+	if err := d.Save(&saved); err != nil {
+		t.Fatalf("Could not save model. %s", err)
+	}
+	expected := saved.String()
+
+	loaded, err := Load(&saved)
+	if err != nil {
+		t.Fatalf("Could not load model. %s", err)
+	}
+	var resaved bytes.Buffer
+	if err := loaded.Save(&resaved); err != nil {
+		t.Fatalf("Could not save loaded model. %s", err)
+	}
+	if resaved.String() != expected {
+		t.Errorf("Loaded model does not match the saved model")
+	}
+}
+
+func TestLoadRejectsOtherFiles(t *testing.T) {
+	if _, err := Load(strings.NewReader("LogList = []")); err == nil {
+		t.Errorf("Expected an error loading a file that is not a model")
+	}
+}
+
+func TestRunWithHandler(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.Source = "auth.log"
+	d := New(opts)
+	in := make(chan string)
+	d.RunWithHandler(context.Background(), in, func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	for i := 0; i < 40; i++ {
+		in <- fmt.Sprintf("user u%d logged in from host h%d", i, i)
+	}
+	in <- "kernel: eth0 link is down"
+	close(in)
+	d.Wait()
+
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly, got %d", len(anomalies))
+	}
+	a := anomalies[0]
+	if a.Line != "kernel: eth0 link is down" {
+		t.Errorf("Line does not match")
+	}
+	if a.Seq != 41 {
+		t.Errorf("Seq does not match")
+		t.Logf("Expected: 41")
+		t.Logf("Actual: %d", a.Seq)
+	}
+	if a.Source != "auth.log" {
+		t.Errorf("Source does not match")
+	}
+	if a.Reason != ReasonNeverMatched {
+		t.Errorf("Reason does not match")
+	}
+}
+
+func TestRunFlushesOnClose(t *testing.T) {
+	var anomalies []Anomaly
+	in := make(chan string)
+	d := New(DefaultOptions())
+	d.RunWithHandler(context.Background(), in, func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	// Nothing has been learned yet, so this line is held back instead of reported
+	in <- "kernel: eth0 link is down"
+	for i := 0; i < 40; i++ {
+		in <- fmt.Sprintf("user u%d logged in from host h%d", i, i)
+	}
+	close(in)
+	d.Wait()
+
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected the pending line to be flushed, got %d anomalies", len(anomalies))
+	}
+	if anomalies[0].Line != "kernel: eth0 link is down" {
+		t.Errorf("Flushed line does not match")
+		t.Logf("Actual: %s", anomalies[0].Line)
+	}
+	if anomalies[0].Seq != 1 {
+		t.Errorf("Flushed line should keep its original position")
+	}
+}
+
+func TestTimeoutUsesLogTime(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.ParseTime = SyslogTime
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	// Nothing has been learned yet, so this line is held back instead of reported
+	d.Analyze("Jan 12 06:00:00 kernel: eth0 link is down")
+	for i := 0; i < 40; i++ {
+		d.Analyze(fmt.Sprintf("Jan 12 06:00:10 user u%d logged in from host h%d", i, i))
+	}
+	if len(anomalies) != 0 {
+		t.Fatalf("Expected nothing to be reported before the timeout, got %d anomalies", len(anomalies))
+	}
+
+	// A minute later in the log the held back line has timed out, however fast the lines were read
+	d.Analyze("Jan 12 06:01:00 kernel: something else entirely happened here")
+	if len(anomalies) == 0 || anomalies[0].Reason != ReasonTimedOut {
+		t.Fatalf("Expected the held back line to time out")
+	}
+	if anomalies[0].Seq != 1 {
+		t.Errorf("Timed out line does not match")
+	}
+	if anomalies[0].Time.Month() != time.January || anomalies[0].Time.Day() != 12 {
+		t.Errorf("Timed out line should keep the time from the log")
+		t.Logf("Actual: %s", anomalies[0].Time)
+	}
+}
+
+func TestSetHandler(t *testing.T) {
+	var anomalies []Anomaly
+	d := New(DefaultOptions())
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	for i := 0; i < 40; i++ {
+		d.Analyze(fmt.Sprintf("user u%d logged in from host h%d", i, i))
+	}
+	d.Analyze("kernel: eth0 link is down")
+	if len(anomalies) != 1 || anomalies[0].Line != "kernel: eth0 link is down" {
+		t.Errorf("Expected the handler to get the anomaly without Run")
+		t.Logf("Actual: %v", anomalies)
+	}
+}
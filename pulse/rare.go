@@ -0,0 +1,43 @@
+package pulse
+
+import "sort"
+
+//how many lines are read between updates of the rare pattern cutoff
+const rareRefreshLines = 1000
+
+//returns true if the pattern is one of the rarest patterns, by how often each has matched
+func (d *Detector) isRare(p *pattern) bool {
+	if d.opts.RarePercentile <= 0 || d.seq < d.opts.RareWarmup {
+		return false
+	}
+
+	//sorting every pattern is too slow for every line, so the cutoff is only refreshed now and then
+	if d.seq >= d.rareRefreshAt {
+		d.rareCutoff = d.percentileMatches(d.opts.RarePercentile)
+		d.rareRefreshAt = d.seq + rareRefreshLines
+	}
+	//the count already includes this line, so compare how often it had matched before
+	return p.numMatches-1 <= d.rareCutoff
+}
+
+//returns the match count below which the supplied fraction of patterns fall
+func (d *Detector) percentileMatches(percentile float64) int64 {
+	if len(d.patterns) == 0 {
+		return 0
+	}
+	counts := make([]int, len(d.patterns))
+	for i := range d.patterns {
+		counts[i] = int(d.patterns[i].numMatches)
+	}
+	sort.Ints(counts)
+
+	index := int(percentile * float64(len(counts)))
+	if index <= 0 {
+		//no pattern falls below the percentile
+		return 0
+	}
+	if index > len(counts) {
+		index = len(counts)
+	}
+	return int64(counts[index-1])
+}
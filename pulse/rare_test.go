@@ -0,0 +1,40 @@
+package pulse_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestRarePattern(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.RarePercentile = 0.5
+	opts.RareWarmup = 1
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	d.Analyze("disk sda is failing at sector 100")
+	d.Analyze("disk sdb is failing at sector 200")
+	for i := 0; i < 100; i++ {
+		d.Analyze(fmt.Sprintf("user u%d logged in from host h%d", i, i))
+	}
+	anomalies = nil
+
+	d.Analyze("user u7 logged in from host h7")
+	if len(anomalies) != 0 {
+		t.Errorf("A common pattern should not be reported")
+	}
+
+	d.Analyze("disk sdc is failing at sector 300")
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected the rare pattern to be reported, got %d anomalies", len(anomalies))
+	}
+	if anomalies[0].Reason != ReasonRarePattern {
+		t.Errorf("Reason does not match")
+	}
+	if anomalies[0].Pattern == "" {
+		t.Errorf("Rare pattern should name the pattern")
+	}
+}
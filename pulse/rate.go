@@ -0,0 +1,83 @@
+package pulse
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//windows are only evaluated one by one up to this many, after a longer gap the rest are skipped
+const maxWindowsPerLine = 1000
+
+//the match rate a pattern has learned
+type patternRate struct {
+	//numMatches when the current window started
+	windowStartMatches int64
+	//moving average of matches per window
+	mean float64
+	//number of windows the average is built from
+	windows int
+}
+
+//closes every window that has ended by now, reporting patterns whose rate changed sharply
+func (d *Detector) advanceWindows(now time.Time) {
+	if d.opts.RateWindow <= 0 {
+		return
+	}
+	if d.windowStart.IsZero() || now.Before(d.windowStart) {
+		d.windowStart = now
+		return
+	}
+
+	for i := 0; !now.Before(d.windowStart.Add(d.opts.RateWindow)); i++ {
+		if i == maxWindowsPerLine {
+			d.windowStart = now
+			return
+		}
+		d.windowStart = d.windowStart.Add(d.opts.RateWindow)
+		for _, p := range d.patterns {
+			d.closeWindow(p, d.windowStart)
+		}
+	}
+}
+
+//evaluates the window that just ended for a pattern and folds it into its average
+func (d *Detector) closeWindow(p *pattern, end time.Time) {
+	r := &p.rate
+	count := float64(p.numMatches - r.windowStartMatches)
+	r.windowStartMatches = p.numMatches
+
+	if r.windows >= d.opts.RateWarmup {
+		var reason Reason
+		baseline := r.mean
+		if baseline < d.opts.RateMinBaseline {
+			baseline = d.opts.RateMinBaseline
+		}
+		if count >= baseline*d.opts.RateSpike {
+			reason = ReasonRateSpike
+		} else if r.mean >= d.opts.RateMinBaseline && count <= r.mean*d.opts.RateDrop {
+			reason = ReasonRateDrop
+		}
+
+		if reason != "" && p.pin != pinBenign {
+			d.reportAnomaly(Anomaly{
+				Time:      end,
+				Seq:       d.seq,
+				Source:    d.opts.Source,
+				Labels:    d.modelLabels(),
+				Pattern:   p.template(),
+				PatternID: p.id,
+				Reason:    reason,
+				Value:     strconv.FormatFloat(count, 'f', -1, 64),
+				Expected:  fmt.Sprintf("about %.1f per %s", r.mean, d.opts.RateWindow),
+			})
+		}
+	}
+
+	if r.windows == 0 {
+		r.mean = count
+	} else {
+		r.mean = d.opts.RateAlpha*count + (1-d.opts.RateAlpha)*r.mean
+	}
+	r.windows++
+}
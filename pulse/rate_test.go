@@ -0,0 +1,62 @@
+package pulse_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func TestRateSpikeAndDrop(t *testing.T) {
+	var anomalies []Anomaly
+	clock := &testClock{time.Date(2016, 1, 23, 0, 0, 0, 0, time.UTC)}
+	opts := DefaultOptions()
+	opts.Clock = clock
+	opts.RateWindow = time.Minute
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	minute := func(links, heartbeats int) {
+		for i := 0; i < links; i++ {
+			d.Analyze(fmt.Sprintf("eth%d: link is down after %d retries", i%4, i))
+		}
+		for i := 0; i < heartbeats; i++ {
+			d.Analyze(fmt.Sprintf("heartbeat %d from node n%d is ok", i, i))
+		}
+		clock.now = clock.now.Add(time.Minute)
+	}
+
+	for i := 0; i < 10; i++ {
+		minute(10, 10)
+	}
+	anomalies = nil
+
+	minute(60, 10)
+	minute(10, 10)
+	if len(anomalies) != 1 || anomalies[0].Reason != ReasonRateSpike {
+		t.Fatalf("Expected a rate spike, got %v", anomalies)
+	}
+	if anomalies[0].Value != "60" {
+		t.Errorf("Spike count does not match")
+		t.Logf("Actual: %s", anomalies[0].Value)
+	}
+	anomalies = nil
+
+	minute(10, 0)
+	minute(10, 10)
+	if len(anomalies) != 1 || anomalies[0].Reason != ReasonRateDrop {
+		t.Fatalf("Expected a rate drop, got %v", anomalies)
+	}
+	if anomalies[0].Line != "" || anomalies[0].Pattern == "" {
+		t.Errorf("Rate anomaly should name the pattern instead of a line")
+	}
+}
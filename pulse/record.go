@@ -0,0 +1,204 @@
+package pulse
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+//Record is a line of input tagged with where it came from.  See AnalyzeRecord and RunRecords.
+type Record struct {
+	//Source names the stream the line was read from, such as a file.  It is copied onto the Anomaly,
+	//in place of Options.Source, and with Options.SplitBySource picks the model the line is learned in.
+	Source string
+
+	Line string
+
+	//Time, when set, is the time of the line, used instead of Options.ParseTime, HeaderTime or Clock
+	Time time.Time
+
+	//Labels are copied onto the Anomaly.  With Options.SplitByLabel the value of that label picks the
+	//model the line is learned in.
+	Labels map[string]string
+}
+
+//AnalyzeRecord runs a single record through the detector the way Analyze runs a line.  With
+//Options.SplitBySource or SplitByLabel the record is learned in the model of its source or label.
+func (d *Detector) AnalyzeRecord(r Record) {
+	c := d.detectorFor(r)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if event := c.assemble(r); event != nil {
+		c.analyze(event)
+	}
+}
+
+//SetHandler sends the anomalies found by Analyze and AnalyzeRecord to handler, without starting Run.
+//The handler is called while the detector is locked, so it must not call the detector.
+func (d *Detector) SetHandler(handler Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handler = handler
+	for _, c := range d.children {
+		c.mu.Lock()
+		c.handler = handler
+		c.mu.Unlock()
+	}
+}
+
+//RunRecords reads records from in on a new goroutine, sending anomalies to handler, in the same way
+//as RunWithHandler.  The models of every source or label share the goroutines of Options.Workers.
+func (d *Detector) RunRecords(ctx context.Context, in <-chan Record, handler Handler) {
+	d.SetHandler(handler)
+	done := make(chan struct{})
+	d.mu.Lock()
+	d.done = done
+	d.mu.Unlock()
+	go func() {
+		defer close(done)
+		defer d.Flush()
+		if d.opts.Workers > 1 {
+			d.runWorkers(ctx, in)
+			return
+		}
+		for {
+			select {
+			case r, ok := <-in:
+				if !ok {
+					return
+				}
+				d.AnalyzeRecord(r)
+			case <-d.idle():
+				d.analyzeIdle()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+//returns the lines read from in as records, until in is closed or ctx is done
+func records(ctx context.Context, in <-chan string) <-chan Record {
+	out := make(chan Record)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case line, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- Record{Line: line}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+//returns the key of the model a record is learned in, and false if the detector keeps a single model
+func (d *Detector) splitKey(r Record) (string, bool) {
+	switch {
+	case d.opts.SplitByLabel != "":
+		return r.Labels[d.opts.SplitByLabel], true
+	case d.opts.SplitBySource:
+		return r.Source, true
+	}
+	return "", false
+}
+
+//returns the detector that learns a record: the detector itself, or the child keeping the model of
+//the record's source or label, which is created the first time it is needed
+func (d *Detector) detectorFor(r Record) *Detector {
+	key, split := d.splitKey(r)
+	if !split {
+		return d
+	}
+	d.mu.RLock()
+	c := d.children[key]
+	d.mu.RUnlock()
+	if c != nil {
+		return c
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.child(key)
+}
+
+//returns the child for a key, creating it if needed, the caller must hold the lock.
+//A child has the options of its parent and takes anomaly ids from it, so ids stay unique.
+func (d *Detector) child(key string) *Detector {
+	if c := d.children[key]; c != nil {
+		return c
+	}
+	opts := d.opts
+	opts.SplitBySource, opts.SplitByLabel, opts.Workers = false, "", 0
+	if d.opts.SplitByLabel == "" {
+		opts.Source = key
+	}
+	c := New(opts)
+	c.parent = d
+	c.key = key
+	c.handler = d.handler
+	c.training, c.trained = d.training, d.trained
+	if d.children == nil {
+		d.children = make(map[string]*Detector)
+	}
+	d.children[key] = c
+	return c
+}
+
+//returns the detector and its children, the children ordered by key
+func (d *Detector) detectors() []*Detector {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]*Detector{d}, d.childList()...)
+}
+
+//returns the children ordered by key, the caller must hold the lock
+func (d *Detector) childList() []*Detector {
+	if len(d.children) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(d.children))
+	for key := range d.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]*Detector, len(keys))
+	for i, key := range keys {
+		list[i] = d.children[key]
+	}
+	return list
+}
+
+//returns the source or label value the detector models: its key if it is a child, otherwise Options.Source
+func (d *Detector) modelKey() string {
+	if d.parent != nil {
+		return d.key
+	}
+	return d.opts.Source
+}
+
+//returns the label a child split by Options.SplitByLabel models, as the labels of an anomaly that is not
+//about a single record
+func (d *Detector) modelLabels() map[string]string {
+	if d.parent == nil || d.parent.opts.SplitByLabel == "" {
+		return nil
+	}
+	return map[string]string{d.parent.opts.SplitByLabel: d.key}
+}
+
+//returns the next anomaly id, shared by a detector and its children
+func (d *Detector) newAnomalyID() int64 {
+	if d.parent != nil {
+		return d.parent.newAnomalyID()
+	}
+	return atomic.AddInt64(&d.nextAnomalyID, 1)
+}
@@ -0,0 +1,90 @@
+package pulse_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+//trains a detector keeping a model per source on nginx and kernel lines
+func trainSources(opts Options, anomalies *[]Anomaly) *Detector {
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { *anomalies = append(*anomalies, a) })
+	in := make(chan Record)
+	go func() {
+		for i := 0; i < 40; i++ {
+			in <- Record{Source: "nginx", Line: fmt.Sprintf("GET /index%d.html returned 200 in %dms", i, i)}
+			in <- Record{Source: "kern", Line: fmt.Sprintf("usb 1-%d: new high-speed USB device number %d", i, i)}
+		}
+		close(in)
+	}()
+	d.TrainRecords(in)
+	return d
+}
+
+func TestSplitBySource(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.SplitBySource = true
+	d := trainSources(opts, &anomalies)
+
+	if m := d.Memory(); m.Patterns != 2 {
+		t.Errorf("Expected a pattern in each of 2 models, got %d patterns", m.Patterns)
+	}
+
+	d.AnalyzeRecord(Record{Source: "kern", Line: "usb 1-7: new high-speed USB device number 7"})
+	labels := map[string]string{"host": "web1"}
+	d.AnalyzeRecord(Record{Source: "nginx", Line: "usb 1-7: new high-speed USB device number 7", Labels: labels})
+	if len(anomalies) != 1 {
+		t.Fatalf("A line should only be known to the model of its source, got %v", anomalies)
+	}
+	if anomalies[0].Source != "nginx" || anomalies[0].Labels["host"] != "web1" {
+		t.Errorf("The anomaly is not tagged with its record")
+		t.Logf("Expected: nginx %v", labels)
+		t.Logf("Actual: %s %v", anomalies[0].Source, anomalies[0].Labels)
+	}
+	if err := d.MarkBenign(anomalies[0].ID); err != nil {
+		t.Errorf("Could not give feedback about an anomaly of a source. %s", err)
+	}
+
+	var saved bytes.Buffer
+	if err := d.Save(&saved); err != nil {
+		t.Fatalf("Could not save model. %s", err)
+	}
+	loaded, err := LoadWithOptions(&saved, opts)
+	if err != nil {
+		t.Fatalf("Could not load model. %s", err)
+	}
+	if m := loaded.Memory(); m.Patterns != 3 {
+		t.Errorf("The models of each source were not kept by Save and Load")
+		t.Logf("Expected: 3 patterns")
+		t.Logf("Actual: %d patterns", m.Patterns)
+	}
+}
+
+func TestSplitBySourceWorkers(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.SplitBySource = true
+	opts.Workers = 4
+	opts.Ordered = true
+	d := trainSources(opts, &anomalies)
+
+	in := make(chan Record)
+	d.RunRecords(context.Background(), in, func(a Anomaly) { anomalies = append(anomalies, a) })
+	for i := 40; i < 60; i++ {
+		in <- Record{Source: "nginx", Line: fmt.Sprintf("GET /index%d.html returned 200 in %dms", i, i)}
+		in <- Record{Source: "kern", Line: fmt.Sprintf("usb 1-%d: new high-speed USB device number %d", i, i)}
+	}
+	in <- Record{Source: "kern", Line: "GET /index7.html returned 200 in 7ms"}
+	close(in)
+	d.Wait()
+
+	if len(anomalies) != 1 || anomalies[0].Source != "kern" {
+		t.Errorf("Expected only the nginx line read from kern to be reported")
+		t.Logf("Actual: %v", anomalies)
+	}
+}
@@ -0,0 +1,168 @@
+package pulse
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+//Action is what a Rule does with the lines it matches
+type Action string
+
+const (
+	//ActionIgnore keeps anomalies about the line from being reported.  The line is still learned from.
+	ActionIgnore Action = "ignore"
+	//ActionAlert reports the line with ReasonAlertRule, even when it matches a pattern
+	ActionAlert Action = "alert"
+)
+
+//Rule silences lines that are known to be noisy, or always reports lines that must never be missed,
+//without retraining the model.  A line matches the rule when it matches Regexp or Template and
+//Source and the time window allow it.  See Options.Rules.
+type Rule struct {
+	//Name identifies the rule, it is copied onto Anomaly.Rule
+	Name string
+
+	//Action is what is done with the lines that match
+	Action Action
+
+	//Regexp, when set, is matched against the whole line, header included
+	Regexp *regexp.Regexp
+
+	//Template, when set, is matched against the tokens of the message, written the way Anomaly.Pattern
+	//shows a pattern.  Words must be equal, <*> stands for one or more tokens and a typed placeholder
+	//such as <INT> for one or more tokens that together are a value of that kind.
+	Template string
+
+	//Source, when set, limits the rule to lines from this Record.Source, or a detector with this Options.Source
+	Source string
+
+	//Start and End, when set, limit the rule to lines whose time is from Start up to End
+	Start time.Time
+	End   time.Time
+}
+
+//the kind each placeholder of a template stands for
+var placeholderKinds = func() map[string]Kind {
+	kinds := map[string]Kind{wildcardPlaceholder: KindText}
+	for kind, placeholder := range kindPlaceholders {
+		kinds[placeholder] = kind
+	}
+	return kinds
+}()
+
+//RuleHits returns how many lines each of Options.Rules has ignored or alerted on, in the same order.
+//The hits of the models kept per source or label are added together.
+func (d *Detector) RuleHits() []int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	hits := append([]int64{}, d.ruleHits...)
+	for _, c := range d.childList() {
+		for i, n := range c.RuleHits() {
+			hits[i] += n
+		}
+	}
+	return hits
+}
+
+//returns the index of the first rule the line of the anomaly matches, or -1
+func (d *Detector) matchRule(a Anomaly) int {
+	if a.Line == "" {
+		return -1
+	}
+	var tokens []string
+	for i, r := range d.opts.Rules {
+		if (r.Source != "" && r.Source != a.Source) || (!r.Start.IsZero() && a.Time.Before(r.Start)) || (!r.End.IsZero() && !a.Time.Before(r.End)) {
+			continue
+		}
+		if r.Regexp != nil && r.Regexp.MatchString(a.Line) {
+			return i
+		}
+		if r.Template != "" {
+			if tokens == nil {
+				tokens = d.opts.Tokenizer.Tokenize(d.message(a.Line))
+			}
+			if fitsTemplate(d.templates[i], tokens) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+//returns the part of the line patterns are learned from
+func (d *Detector) message(line string) string {
+	if d.opts.StripHeaders {
+		_, body := ParseHeader(line)
+		return body
+	}
+	return line
+}
+
+//reports the line of a commit with ReasonAlertRule if an alert rule matches it and nothing else reported it
+func (d *Detector) alertRule(a *Anomaly) {
+	if d.training {
+		return
+	}
+	i := d.matchRule(*a)
+	if i < 0 || d.opts.Rules[i].Action != ActionAlert {
+		return
+	}
+	d.ruleHits[i]++
+	if d.lineSent != a.Seq {
+		a.Reason = ReasonAlertRule
+		d.send(*a)
+	}
+}
+
+//returns true if the tokens fit the words of a template, a placeholder taking one or more tokens.
+//The words are fitted from the last, fits[t] holding whether the words after the current one fit
+//tokens[t:], so each word is tried once from each token instead of backtracking over every split.
+func fitsTemplate(words, tokens []string) bool {
+	n := len(tokens)
+	fits := make([]bool, n+1)
+	fits[n] = true
+	for w := len(words) - 1; w >= 0; w-- {
+		next := make([]bool, n+1)
+		kind, placeholder := placeholderKinds[words[w]]
+		for t := n - 1; t >= 0; t-- {
+			switch {
+			case !placeholder:
+				next[t] = tokens[t] == words[w] && fits[t+1]
+			case kind == KindText:
+				next[t] = fits[t+1] || next[t+1]
+			default:
+				var value strings.Builder
+				for i := t; i < n && !next[t]; i++ {
+					value.WriteString(tokens[i])
+					next[t] = fits[i+1] && mergeKind(kind, inferKind(value.String())) == kind
+				}
+			}
+		}
+		fits = next
+	}
+	return fits[0]
+}
+
+var placeholderPattern = regexp.MustCompile(`<(?:\*|[A-Z0-9]+)>`)
+
+//splits the templates of the rules into words once, rather than for every line.  The text between
+//placeholders is split by the tokenizer, so a template can be written as the line would be.
+func ruleTemplates(rules []Rule, tokenizer Tokenizer) [][]string {
+	templates := make([][]string, len(rules))
+	for i := range rules {
+		template := rules[i].Template
+		var words []string
+		last := 0
+		for _, loc := range placeholderPattern.FindAllStringIndex(template, -1) {
+			if _, ok := placeholderKinds[template[loc[0]:loc[1]]]; !ok {
+				continue
+			}
+			words = append(words, tokenizer.Tokenize(template[last:loc[0]])...)
+			words = append(words, template[loc[0]:loc[1]])
+			last = loc[1]
+		}
+		templates[i] = append(words, tokenizer.Tokenize(template[last:])...)
+	}
+	return templates
+}
@@ -0,0 +1,85 @@
+package pulse_test
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestRules(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.Rules = []Rule{
+		{Name: "disk", Action: ActionIgnore, Regexp: regexp.MustCompile(`^disk `)},
+		{Name: "root", Action: ActionAlert, Template: "user root logged in from host <*>"},
+		{Name: "expired", Action: ActionAlert, Template: "user <*> logged in from host h9", End: time.Now().Add(-time.Hour)},
+		{Name: "pid", Action: ActionAlert, Template: "Out of memory: Kill process <INT>"},
+	}
+	d := trainLogins(opts, &anomalies)
+
+	d.Analyze("disk sda1 is full")
+	d.Analyze("user u9 logged in from host h9")
+	d.Analyze("user root logged in from host h3")
+	d.Analyze("Out of memory: Kill process 1234")
+	d.Analyze("Out of memory: Kill process init")
+
+	var alerts []string
+	for _, a := range anomalies {
+		if a.Rule != "" {
+			alerts = append(alerts, a.Rule)
+		} else if a.Line != "Out of memory: Kill process init" {
+			t.Errorf("Unexpected anomaly %s for %q", a.Reason, a.Line)
+		}
+	}
+	if len(alerts) != 2 || alerts[0] != "root" || alerts[1] != "pid" || anomalies[0].Reason != ReasonAlertRule {
+		t.Errorf("Alert rules did not report the expected lines")
+		t.Logf("Expected: [root pid]")
+		t.Logf("Actual: %v", alerts)
+	}
+
+	hits := d.RuleHits()
+	if hits[0] != 1 || hits[1] != 1 || hits[2] != 0 || hits[3] != 1 {
+		t.Errorf("Rule hits do not match")
+		t.Logf("Expected: [1 1 0 1]")
+		t.Logf("Actual: %v", hits)
+	}
+}
+
+func TestRuleTemplateLongLine(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.Rules = []Rule{{Name: "zzz", Action: ActionAlert, Template: "<*> <*> <*> <*> zzz"}}
+	d := trainLogins(opts, &anomalies)
+
+	//a template of several wildcards could be fitted to a long line in too many ways to try them all
+	words := make([]string, 120)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", i)
+	}
+	done := make(chan bool)
+	go func() {
+		d.Analyze(strings.Join(words, " "))
+		d.Analyze(strings.Join(words, " ") + " zzz")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Matching the template against a long line took over a second")
+	}
+
+	var alerts []string
+	for _, a := range anomalies {
+		if a.Rule != "" {
+			alerts = append(alerts, a.Line[len(a.Line)-3:])
+		}
+	}
+	if len(alerts) != 1 || alerts[0] != "zzz" {
+		t.Errorf("Only the line ending in zzz should be alerted on")
+		t.Logf("Actual: %v", alerts)
+	}
+}
@@ -0,0 +1,386 @@
+package pulse
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//the sequence model learns the order patterns are matched in.  transitions counts which pattern follows
+//each run of Options.SequenceOrder patterns, followers counts which patterns follow each pattern within
+//Options.SequenceTimeout.  The order is followed separately for each source, so lines of different
+//files read together do not look like they follow each other.
+type sequenceModel struct {
+	//the ids of the latest patterns matched from each source, oldest first
+	chains map[string][]int64
+	//how often each run of patterns was seen, and how often each pattern followed it
+	contexts    map[string]int64
+	transitions map[string]map[int64]int64
+	//how many matches of each pattern have had their SequenceTimeout run out, and how many of those
+	//each other pattern followed in time
+	closed    map[int64]int64
+	followers map[int64]map[int64]int64
+	//matches from each source waiting for their SequenceTimeout to run out, oldest first
+	open map[string][]occurrence
+}
+
+//a match of a pattern and the patterns matched after it so far
+type occurrence struct {
+	anomaly  Anomaly
+	deadline time.Time
+	seen     map[int64]bool
+	//the patterns that nearly always followed the pattern, when it was matched
+	expect []int64
+}
+
+func newSequenceModel() *sequenceModel {
+	return &sequenceModel{
+		chains:      make(map[string][]int64),
+		open:        make(map[string][]occurrence),
+		contexts:    make(map[string]int64),
+		transitions: make(map[string]map[int64]int64),
+		closed:      make(map[int64]int64),
+		followers:   make(map[int64]map[int64]int64),
+	}
+}
+
+//copies the counts of the model into a snapshot, so it can be encoded once the lock is released
+func (s *sequenceModel) snapshot() *sequenceSnapshot {
+	snap := &sequenceSnapshot{
+		Contexts:    make(map[string]int64, len(s.contexts)),
+		Transitions: make(map[string]map[int64]int64, len(s.transitions)),
+		Closed:      make(map[int64]int64, len(s.closed)),
+		Followers:   make(map[int64]map[int64]int64, len(s.followers)),
+	}
+	for key, count := range s.contexts {
+		snap.Contexts[key] = count
+	}
+	for key, next := range s.transitions {
+		snap.Transitions[key] = copyCounts(next)
+	}
+	for id, count := range s.closed {
+		snap.Closed[id] = count
+	}
+	for id, followers := range s.followers {
+		snap.Followers[id] = copyCounts(followers)
+	}
+	return snap
+}
+
+func copyCounts(counts map[int64]int64) map[int64]int64 {
+	c := make(map[int64]int64, len(counts))
+	for id, count := range counts {
+		c[id] = count
+	}
+	return c
+}
+
+//returns the key of a run of pattern ids
+func contextKey(ids []int64) string {
+	var key []byte
+	for i, id := range ids {
+		if i > 0 {
+			key = append(key, ',')
+		}
+		key = strconv.AppendInt(key, id, 10)
+	}
+	return string(key)
+}
+
+//returns the ids of a run of patterns from its key
+func contextIDs(key string) []int64 {
+	var ids []int64
+	for _, field := range strings.Split(key, ",") {
+		id, _ := strconv.ParseInt(field, 10, 64)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+//returns true if the run of patterns with the key holds the pattern
+func contextHas(key string, id int64) bool {
+	for _, i := range contextIDs(key) {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+//forgets everything learned about an evicted pattern, so the counts do not outgrow Options.MaxPatterns
+func (s *sequenceModel) forget(id int64) {
+	for key, next := range s.transitions {
+		if contextHas(key, id) {
+			delete(s.transitions, key)
+			delete(s.contexts, key)
+		} else {
+			delete(next, id)
+		}
+	}
+	delete(s.closed, id)
+	delete(s.followers, id)
+	for _, followers := range s.followers {
+		delete(followers, id)
+	}
+	//a run that held the pattern starts again, rather than joining patterns that did not follow each other
+	for source, chain := range s.chains {
+		for _, i := range chain {
+			if i == id {
+				delete(s.chains, source)
+				break
+			}
+		}
+	}
+	for source, open := range s.open {
+		kept := open[:0]
+		for _, o := range open {
+			if o.anomaly.PatternID == id {
+				continue
+			}
+			delete(o.seen, id)
+			o.expect = removeID(o.expect, id)
+			kept = append(kept, o)
+		}
+		if len(kept) == 0 {
+			delete(s.open, source)
+		} else {
+			s.open[source] = kept
+		}
+	}
+}
+
+//moves everything learned about pattern from onto pattern to, when Consolidate merges them
+func (s *sequenceModel) remap(from, to int64) {
+	if from == to {
+		return
+	}
+	keys := make([]string, 0, len(s.transitions))
+	for key := range s.transitions {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		next := s.transitions[key]
+		moveCount(next, from, to)
+		if !contextHas(key, from) {
+			continue
+		}
+		ids := contextIDs(key)
+		for i := range ids {
+			if ids[i] == from {
+				ids[i] = to
+			}
+		}
+		remapped := contextKey(ids)
+		if s.transitions[remapped] == nil {
+			s.transitions[remapped] = make(map[int64]int64)
+		}
+		for id, count := range next {
+			s.transitions[remapped][id] += count
+		}
+		s.contexts[remapped] += s.contexts[key]
+		delete(s.transitions, key)
+		delete(s.contexts, key)
+	}
+
+	moveCount(s.closed, from, to)
+	if followers := s.followers[from]; followers != nil {
+		if s.followers[to] == nil {
+			s.followers[to] = make(map[int64]int64)
+		}
+		for id, count := range followers {
+			s.followers[to][id] += count
+		}
+		delete(s.followers, from)
+	}
+	for id, followers := range s.followers {
+		moveCount(followers, from, to)
+		//a match followed by both patterns was counted for each, but may only be counted once
+		for follower, count := range followers {
+			if count > s.closed[id] {
+				followers[follower] = s.closed[id]
+			}
+		}
+	}
+
+	for _, chain := range s.chains {
+		for i := range chain {
+			if chain[i] == from {
+				chain[i] = to
+			}
+		}
+	}
+	for _, open := range s.open {
+		for i := range open {
+			o := &open[i]
+			if o.anomaly.PatternID == from {
+				o.anomaly.PatternID = to
+			}
+			if o.seen[from] {
+				delete(o.seen, from)
+				o.seen[to] = true
+			}
+			if kept := removeID(o.expect, from); len(kept) < len(o.expect) {
+				o.expect = append(removeID(kept, to), to)
+			}
+		}
+	}
+}
+
+//adds the count of from onto the count of to
+func moveCount(counts map[int64]int64, from, to int64) {
+	if count, ok := counts[from]; ok {
+		counts[to] += count
+		delete(counts, from)
+	}
+}
+
+//returns the ids without id
+func removeID(ids []int64, id int64) []int64 {
+	kept := ids[:0:0]
+	for _, i := range ids {
+		if i != id {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+//learns that the line matched p and returns an anomaly if p seldom follows the patterns before it.
+//a is the anomaly for the line, with its pattern filled in.
+func (d *Detector) observeSequence(p *pattern, a Anomaly) (Anomaly, bool) {
+	s := d.sequence
+	if s == nil {
+		return Anomaly{}, false
+	}
+	chain := s.chains[a.Source]
+	a.Chain = append([]int64{}, chain...)
+	result, found := Anomaly{}, false
+
+	if d.opts.SequenceOrder > 0 && len(chain) == d.opts.SequenceOrder {
+		key := contextKey(chain)
+		next := s.transitions[key]
+		if seen := s.contexts[key]; seen >= d.opts.SequenceWarmup && float64(next[p.id])/float64(seen) < d.opts.SequenceMinProbability {
+			result, found = a, true
+			result.Reason = ReasonRareTransition
+			result.Expected = d.likeliestNext(next)
+		}
+		if next == nil {
+			next = make(map[int64]int64)
+			s.transitions[key] = next
+		}
+		next[p.id]++
+		s.contexts[key]++
+	}
+	chain = append(chain, p.id)
+	if len(chain) > d.opts.SequenceOrder {
+		chain = chain[1:]
+	}
+	s.chains[a.Source] = chain
+
+	if d.opts.SequenceTimeout > 0 {
+		open := s.open[a.Source]
+		for i := range open {
+			if open[i].seen == nil {
+				open[i].seen = make(map[int64]bool)
+			}
+			open[i].seen[p.id] = true
+		}
+		o := occurrence{anomaly: a, deadline: a.Time.Add(d.opts.SequenceTimeout), expect: d.expectedFollowers(p.id)}
+		o.anomaly.Chain = append(o.anomaly.Chain, p.id)
+		open = append(open, o)
+		if len(open) > d.opts.SequenceMaxOpen {
+			open = open[1:]
+		}
+		s.open[a.Source] = open
+	}
+	return result, found
+}
+
+//returns the template of the pattern that most often follows a run of patterns
+func (d *Detector) likeliestNext(next map[int64]int64) string {
+	var best *pattern
+	var bestCount int64
+	for id, count := range next {
+		if p := d.byID[id]; p != nil && (count > bestCount || (count == bestCount && id < best.id)) {
+			best, bestCount = p, count
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.template()
+}
+
+//returns the patterns that followed the pattern within SequenceTimeout nearly every time it was matched
+func (d *Detector) expectedFollowers(id int64) []int64 {
+	s := d.sequence
+	closed := s.closed[id]
+	if closed < d.opts.SequenceWarmup {
+		return nil
+	}
+	var expect []int64
+	for follower, count := range s.followers[id] {
+		if float64(count)/float64(closed) >= d.opts.SequenceExpected {
+			expect = append(expect, follower)
+		}
+	}
+	return expect
+}
+
+//learns from the matches whose SequenceTimeout has run out by now, reporting the ones that were
+//not followed by a pattern that nearly always follows them
+func (d *Detector) closeOccurrences(now time.Time) {
+	s := d.sequence
+	if s == nil {
+		return
+	}
+	//sources are closed in order, so anomalies are reported in the same order every time
+	sources := make([]string, 0, len(s.open))
+	for source := range s.open {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		d.closeSource(source, now)
+	}
+}
+
+//closes the matches of a source whose SequenceTimeout has run out by now
+func (d *Detector) closeSource(source string, now time.Time) {
+	s := d.sequence
+	open := s.open[source]
+	for len(open) > 0 && now.After(open[0].deadline) {
+		o := open[0]
+		open = open[1:]
+
+		id := o.anomaly.PatternID
+		s.closed[id]++
+		followers := s.followers[id]
+		if followers == nil {
+			followers = make(map[int64]int64)
+			s.followers[id] = followers
+		}
+		for follower := range o.seen {
+			followers[follower]++
+		}
+
+		if p := d.byID[id]; p == nil || p.pin == pinBenign {
+			continue
+		}
+		for _, follower := range o.expect {
+			if q := d.byID[follower]; q != nil && !o.seen[follower] {
+				a := o.anomaly
+				a.Time = o.deadline
+				a.Reason = ReasonMissingSuccessor
+				a.Expected = q.template()
+				d.reportAnomaly(a)
+			}
+		}
+	}
+	if len(open) == 0 {
+		delete(s.open, source)
+	} else {
+		s.open[source] = open
+	}
+}
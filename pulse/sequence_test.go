@@ -0,0 +1,183 @@
+package pulse_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+//trains a new detector on the lines
+func trainLines(opts Options, lines []string, anomalies *[]Anomaly) *Detector {
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { *anomalies = append(*anomalies, a) })
+	in := make(chan string)
+	go func() {
+		for _, line := range lines {
+			in <- line
+		}
+		close(in)
+	}()
+	d.Train(in)
+	return d
+}
+
+func TestRareTransition(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.SequenceOrder = 1
+	opts.SequenceWarmup = 20
+	var lines []string
+	for i := 0; i < 30; i++ {
+		lines = append(lines, fmt.Sprintf("session opened for user u%d by uid %d", i, i))
+		lines = append(lines, fmt.Sprintf("disk usage checked on /dev/sda%d", i))
+	}
+	d := trainLines(opts, lines, &anomalies)
+
+	d.Analyze("session opened for user u30 by uid 30")
+	d.Analyze("session opened for user u31 by uid 31")
+	if len(anomalies) != 1 || anomalies[0].Reason != ReasonRareTransition {
+		t.Fatalf("Expected a rare transition, got %v", anomalies)
+	}
+	a := anomalies[0]
+	if a.Line != "session opened for user u31 by uid 31" || len(a.Chain) != 1 || a.Chain[0] != a.PatternID {
+		t.Errorf("The rare transition does not carry the line and the pattern before it")
+		t.Logf("Actual: %q after %v", a.Line, a.Chain)
+	}
+	if a.Expected == "" || a.Expected == a.Pattern {
+		t.Errorf("The rare transition does not name the pattern that usually follows")
+		t.Logf("Actual: %q", a.Expected)
+	}
+}
+
+func TestMissingSuccessor(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.StripHeaders = true
+	opts.HeaderTime = true
+	opts.SequenceTimeout = time.Minute
+	opts.SequenceWarmup = 20
+
+	now := time.Date(2016, 1, 23, 0, 0, 0, 0, time.UTC)
+	session := func(i int, backup bool) []string {
+		lines := []string{fmt.Sprintf("%s session opened for user u%d by uid %d", now.Format(time.RFC3339), i, i)}
+		if backup {
+			lines = append(lines, fmt.Sprintf("%s backup job %d finished in %d seconds", now.Add(10*time.Second).Format(time.RFC3339), i, i))
+		}
+		now = now.Add(2 * time.Minute)
+		return lines
+	}
+
+	var lines []string
+	for i := 0; i < 30; i++ {
+		lines = append(lines, session(i, true)...)
+	}
+	d := trainLines(opts, lines, &anomalies)
+
+	opened := session(30, false)
+	for _, line := range append(opened, session(31, true)...) {
+		d.Analyze(line)
+	}
+	var missing []Anomaly
+	for _, a := range anomalies {
+		if a.Reason == ReasonMissingSuccessor {
+			missing = append(missing, a)
+		}
+	}
+	if len(missing) != 1 {
+		t.Fatalf("Expected a missing successor, got %v", anomalies)
+	}
+	if missing[0].Line != opened[0] || missing[0].Expected != "backup job <INT> finished in <INT> seconds" {
+		t.Errorf("The missing successor does not carry the line and the pattern expected after it")
+		t.Logf("Expected: %q expecting %q", opened[0], "backup job <INT> finished in <INT> seconds")
+		t.Logf("Actual: %q expecting %q", missing[0].Line, missing[0].Expected)
+	}
+}
+
+func TestSequencePerSource(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.SequenceOrder = 1
+	opts.SequenceWarmup = 20
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+	in := make(chan Record)
+	go func() {
+		for i := 0; i < 30; i++ {
+			in <- Record{Source: "auth", Line: fmt.Sprintf("session opened for user u%d by uid %d", i, i)}
+			in <- Record{Source: "auth", Line: fmt.Sprintf("disk usage checked on /dev/sda%d", i)}
+			in <- Record{Source: "kern", Line: fmt.Sprintf("usb 1-%d: new high-speed USB device number %d", i, i)}
+		}
+		close(in)
+	}()
+	d.TrainRecords(in)
+
+	//a kernel line between two auth lines has not been seen before, but each file keeps its own order
+	d.AnalyzeRecord(Record{Source: "auth", Line: "session opened for user u30 by uid 30"})
+	d.AnalyzeRecord(Record{Source: "kern", Line: "usb 1-30: new high-speed USB device number 30"})
+	d.AnalyzeRecord(Record{Source: "auth", Line: "disk usage checked on /dev/sda30"})
+	if len(anomalies) != 0 {
+		t.Fatalf("Lines of one source were taken to follow lines of another, got %v", anomalies)
+	}
+
+	d.AnalyzeRecord(Record{Source: "kern", Line: "usb 1-31: new high-speed USB device number 31"})
+	d.AnalyzeRecord(Record{Source: "auth", Line: "disk usage checked on /dev/sda31"})
+	if len(anomalies) != 1 || anomalies[0].Reason != ReasonRareTransition || anomalies[0].Source != "auth" {
+		t.Errorf("Expected a rare transition within the auth source")
+		t.Logf("Actual: %v", anomalies)
+	}
+}
+
+func TestSaveWhileLearningSequences(t *testing.T) {
+	opts := DefaultOptions()
+	opts.SequenceOrder = 1
+	opts.SequenceTimeout = time.Millisecond
+	d := New(opts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			d.Analyze(fmt.Sprintf("session opened for user u%d by uid %d", i, i))
+			d.Analyze(fmt.Sprintf("disk usage checked on /dev/sda%d", i))
+		}
+	}()
+	//run with -race, the model is saved from a copy of the sequence counts
+	for i := 0; i < 20; i++ {
+		if err := d.Save(ioutil.Discard); err != nil {
+			t.Fatalf("Could not save model. %s", err)
+		}
+	}
+	<-done
+}
+
+func TestSequenceForgetsEvictedPatterns(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.MaxPatterns = 3
+	opts.SequenceOrder = 1
+	opts.SequenceTimeout = time.Nanosecond
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	formats := []string{
+		"user u%d logged in from host h%d",
+		"disk sd%d is %d percent full",
+		"link eth%d is down after %d retries",
+		"job j%d finished in %d seconds",
+		"cache c%d evicted %d entries",
+	}
+	//patterns evicted and learned again under new ids must not leave their counts behind
+	for i := 0; i < 60; i++ {
+		for _, format := range formats {
+			d.Analyze(fmt.Sprintf(format, i, i))
+		}
+	}
+	m := d.Memory()
+	if m.EvictedPatterns == 0 || m.SequenceEntries > 2*opts.MaxPatterns*opts.MaxPatterns {
+		t.Errorf("Expected the sequence counts to be bounded by the patterns kept")
+		t.Logf("Expected: at most %d entries", 2*opts.MaxPatterns*opts.MaxPatterns)
+		t.Logf("Actual: %d entries, %d evicted patterns", m.SequenceEntries, m.EvictedPatterns)
+	}
+}
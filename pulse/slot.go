@@ -0,0 +1,182 @@
+package pulse
+
+import "strings"
+
+//a value seen in a wildcard slot of a pattern, index is the position of the token in the pattern
+type slotValue struct {
+	index int
+	value string
+}
+
+//returns a new wildcard token holding the supplied variations
+func newWildcard(variations []variation) token {
+	t := token{word: "!WILDCARD!", variable: true, required: len(variations) > 1, variations: variations}
+	for i := range variations {
+		t.learnKind(variations[i].text)
+		t.learnNumber(variations[i].text)
+	}
+	return t
+}
+
+//returns a wildcard for the tokens that are left after the last shared token, so a value at
+//the end of a line such as "port 22" gets a slot too
+func trailingWildcard(shortTokens []string, longTokens []string, lastPoint vertex) (token, bool) {
+	skippedColText := ""
+	skippedRowText := ""
+	for x := lastPoint.x + 1; x < len(shortTokens); x++ {
+		skippedColText += shortTokens[x]
+	}
+	if skippedColText == "!WILDCARD!" {
+		skippedColText = ""
+	}
+	for y := lastPoint.y + 1; y < len(longTokens); y++ {
+		skippedRowText += longTokens[y]
+	}
+	if skippedColText == "" && skippedRowText == "" {
+		return token{}, false
+	}
+
+	var variableText []variation
+	if skippedColText != "" {
+		variableText = append(variableText, variation{skippedColText, 1})
+	}
+	if skippedRowText != "" {
+		variableText = append(variableText, variation{skippedRowText, 1})
+	}
+	return newWildcard(variableText), true
+}
+
+//widens the kind of the slot to include value
+func (t *token) learnKind(value string) {
+	if value == "" {
+		return
+	}
+	t.kind = mergeKind(t.kind, inferKind(value))
+	t.samples++
+}
+
+//counts a value seen in the slot.  Once limit distinct values are kept, new values are
+//counted in a single otherVariation bucket instead.
+func (t *token) addVariation(value string, limit int) {
+	t.addVariations(value, 1, limit)
+}
+
+//counts a value seen in the slot count times
+func (t *token) addVariations(value string, count int64, limit int) {
+	for i := range t.variations {
+		if t.variations[i].text == value {
+			t.variations[i].numMatches += count
+			return
+		}
+	}
+	if limit > 0 && len(t.variations) >= limit {
+		value = otherVariation
+		for i := range t.variations {
+			if t.variations[i].text == otherVariation {
+				t.variations[i].numMatches += count
+				return
+			}
+		}
+	}
+	t.variations = append(t.variations, variation{value, count})
+}
+
+//returns the position of the token among the wildcards of the pattern, counting from 1
+func (p *pattern) slotNumber(index int) int {
+	slot := 0
+	for i := 0; i <= index && i < len(p.tokens); i++ {
+		if p.tokens[i].variable {
+			slot++
+		}
+	}
+	return slot
+}
+
+//records the values a matched line had in the wildcard slots of a pattern.  If a value does not fit
+//what its slot has learned, an anomaly built from base is returned for the first such value.
+func (d *Detector) observeSlots(p *pattern, values []slotValue, base Anomaly) (Anomaly, bool) {
+	var result Anomaly
+	found := false
+	for _, sv := range values {
+		//a slot the line has no word for has no value to check or learn, as in learnKind
+		if sv.value == "" {
+			continue
+		}
+		t := &p.tokens[sv.index]
+		if !found {
+			result, found = d.checkSlot(p, sv, base)
+		}
+
+		known := t.hasVariation(sv.value)
+		settled := d.settled(t)
+		t.addVariation(sv.value, d.opts.MaxVariations)
+		//a new value the slot was settled enough to report does not unsettle it, so a second one is reported too
+		switch {
+		case known:
+			t.stable++
+		case d.training || !d.opts.ReportNewValues || !settled:
+			t.stable = 0
+		}
+		//outliers are not learned, so that one spike does not widen the range of the slot
+		if d.training || !d.opts.ReportOutliers {
+			t.learnNumber(sv.value)
+		} else if _, outlier := d.checkOutlier(t, sv.value); !outlier {
+			t.learnNumber(sv.value)
+		}
+		//once the kind is fixed, values that do not fit are reported instead of widening it
+		if !d.opts.ReportTypeMismatch || t.samples < int64(d.opts.KindMinSamples) {
+			t.learnKind(sv.value)
+		}
+	}
+	return result, found
+}
+
+//checks a value against what its slot has learned so far
+func (d *Detector) checkSlot(p *pattern, sv slotValue, base Anomaly) (Anomaly, bool) {
+	t := &p.tokens[sv.index]
+	a := base
+	a.Slot = p.slotNumber(sv.index)
+	a.Value = sv.value
+
+	if d.opts.ReportTypeMismatch && t.samples >= int64(d.opts.KindMinSamples) && t.kind != KindText {
+		if kind := inferKind(sv.value); kind != KindUnknown && mergeKind(t.kind, kind) != t.kind {
+			a.Reason = ReasonTypeMismatch
+			a.Expected = t.kind.Placeholder()
+			return a, true
+		}
+	}
+	if d.opts.ReportOutliers {
+		if expected, ok := d.checkOutlier(t, sv.value); ok {
+			a.Reason = ReasonValueOutlier
+			a.Expected = expected
+			return a, true
+		}
+	}
+	if d.opts.ReportNewValues && d.settled(t) && !t.hasVariation(sv.value) {
+		a.Reason = ReasonNewValue
+		var known []string
+		for _, v := range t.variations {
+			known = append(known, v.text)
+		}
+		a.Expected = strings.Join(known, "|")
+		return a, true
+	}
+	return Anomaly{}, false
+}
+
+//returns true if the value has been seen in the slot
+func (t *token) hasVariation(value string) bool {
+	for i := range t.variations {
+		if t.variations[i].text == value {
+			return true
+		}
+	}
+	return false
+}
+
+//returns true if the slot holds a small set of values that has not grown for Options.NewValueStable
+//values, so a value it has never seen is worth reporting.  Slots holding ids or times never settle,
+//as they have more than Options.NewValueMaxCardinality values or keep seeing new ones.
+func (d *Detector) settled(t *token) bool {
+	return t.stable >= d.opts.NewValueStable && len(t.variations) <= d.opts.NewValueMaxCardinality && !t.hasVariation(otherVariation)
+}
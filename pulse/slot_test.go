@@ -0,0 +1,97 @@
+package pulse_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestTypedWildcards(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.Tokenizer = StructuredTokenizer{}
+	opts.ReportTypeMismatch = true
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { anomalies = append(anomalies, a) })
+
+	for i := 0; i < 60; i++ {
+		d.Analyze(fmt.Sprintf("Failed login from 10.0.%d.%d port %d", i%7, i, 1000+i*37))
+	}
+	if len(anomalies) != 0 {
+		t.Fatalf("Expected no anomalies while learning, got %d", len(anomalies))
+	}
+
+	d.Analyze("Failed login from 10.0.0.1 port ssh")
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected text in an integer slot to be reported")
+	}
+	a := anomalies[0]
+	if a.Reason != ReasonTypeMismatch {
+		t.Errorf("Reason does not match")
+	}
+	if a.Pattern != "Failed login from <IP> port <INT>" {
+		t.Errorf("Template does not match")
+		t.Logf("Actual: %s", a.Pattern)
+	}
+	if a.Slot != 2 || a.Value != "ssh" || a.Expected != "<INT>" {
+		t.Errorf("Slot does not match")
+		t.Logf("Actual: %d %s %s", a.Slot, a.Value, a.Expected)
+	}
+}
+
+func TestKindPlaceholder(t *testing.T) {
+	if KindIPv4.Placeholder() != "<IP>" {
+		t.Errorf("IPv4 placeholder does not match")
+	}
+	if KindText.Placeholder() != "<*>" || KindUnknown.Placeholder() != "<*>" {
+		t.Errorf("Free text should be shown as <*>")
+	}
+	if KindDuration.String() != "duration" {
+		t.Errorf("Kind name does not match")
+	}
+}
+
+func TestNewValue(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.ReportNewValues = true
+	var lines []string
+	for i := 0; i < 300; i++ {
+		lines = append(lines, fmt.Sprintf("eth%d: link is up at 1000 Mbps, session %d", i%2, i))
+	}
+	d := trainLines(opts, lines, &anomalies)
+
+	d.Analyze("eth1: link is up at 1000 Mbps, session 300")
+	d.Analyze("eth7: link is up at 1000 Mbps, session 301")
+	d.Analyze("eth7: link is up at 1000 Mbps, session 302")
+	if len(anomalies) != 1 || anomalies[0].Reason != ReasonNewValue {
+		t.Fatalf("Expected one new value, got %v", anomalies)
+	}
+	if anomalies[0].Value != "eth7" || anomalies[0].Expected != "eth0|eth1" {
+		t.Errorf("The new value does not carry the values seen before")
+		t.Logf("Expected: eth7 instead of eth0|eth1")
+		t.Logf("Actual: %s instead of %s", anomalies[0].Value, anomalies[0].Expected)
+	}
+}
+
+func TestNewValueSkipsEmpty(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.ReportNewValues = true
+	var lines []string
+	for i := 0; i < 300; i++ {
+		lines = append(lines, fmt.Sprintf("eth%d: link is up at 1000 Mbps, session %d", i%2, i))
+	}
+	d := trainLines(opts, lines, &anomalies)
+
+	//the line has nothing where the interface would be, which is not a new interface
+	d.Analyze(": link is up at 1000 Mbps, session 300")
+	d.Analyze("eth7: link is up at 1000 Mbps, session 301")
+	if len(anomalies) != 1 || anomalies[0].Reason != ReasonNewValue || anomalies[0].Value != "eth7" {
+		t.Errorf("Expected only the new value eth7")
+		for _, a := range anomalies {
+			t.Logf("Actual: %s %q", a.Reason, a.Value)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package pulse
+
+import (
+	"regexp"
+	"strings"
+)
+
+//Tokenizer splits the message of a line into the tokens patterns are learned from.
+//A saved model must be loaded with the same Tokenizer it was learned with.
+type Tokenizer interface {
+	Tokenize(line string) []string
+}
+
+//DefaultTokenizer makes every symbol its own token and keeps runs of letters and digits together,
+//so "10.0.0.1" becomes seven tokens.  Whitespace is dropped.
+type DefaultTokenizer struct{}
+
+//Tokenize splits the line into symbols and runs of letters and digits
+func (DefaultTokenizer) Tokenize(line string) []string {
+	return getTokens(line)
+}
+
+//WhitespaceTokenizer splits a line on whitespace only
+type WhitespaceTokenizer struct{}
+
+//Tokenize splits the line on whitespace
+func (WhitespaceTokenizer) Tokenize(line string) []string {
+	return strings.Fields(line)
+}
+
+//StructuredTokenizer keeps IP addresses, paths, UUIDs, MAC addresses, hex strings and key=value pairs
+//as single tokens.  Anything else is split the same way as DefaultTokenizer.
+type StructuredTokenizer struct{}
+
+var structuredWords = []*regexp.Regexp{
+	regexp.MustCompile(`^\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?$`),
+	regexp.MustCompile(`^[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}$`),
+	regexp.MustCompile(`^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`),
+	regexp.MustCompile(`^[0-9A-Fa-f]{2}(?:[:-][0-9A-Fa-f]{2}){5}$`),
+	regexp.MustCompile(`^(?:0[xX])?[0-9A-Fa-f]*[0-9][0-9A-Fa-f]*$`),
+	regexp.MustCompile(`^~?(?:\.{0,2}/)[^\s]*$`),
+	regexp.MustCompile(`^[A-Za-z_][\w.\-]*=\S*$`),
+}
+
+//symbols that are split from the ends of a word before it is checked,
+//a trailing colon or full stop is split as well
+const structuredTrim = "\"'()[]{}<>,;"
+
+//Tokenize splits the line on whitespace, keeping structured words whole
+func (StructuredTokenizer) Tokenize(line string) []string {
+	var result []string
+	for _, field := range strings.Fields(line) {
+		var trailing []string
+		for len(field) > 0 && strings.IndexByte(structuredTrim, field[0]) >= 0 {
+			result = append(result, field[:1])
+			field = field[1:]
+		}
+		for len(field) > 0 && strings.IndexByte(structuredTrim+":.", field[len(field)-1]) >= 0 {
+			trailing = append([]string{field[len(field)-1:]}, trailing...)
+			field = field[:len(field)-1]
+		}
+
+		if field != "" {
+			if isStructuredWord(field) {
+				result = append(result, field)
+			} else {
+				result = append(result, getTokens(field)...)
+			}
+		}
+		result = append(result, trailing...)
+	}
+	return result
+}
+
+func isStructuredWord(word string) bool {
+	for _, re := range structuredWords {
+		if re.MatchString(word) {
+			return true
+		}
+	}
+	return false
+}
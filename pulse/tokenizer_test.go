@@ -0,0 +1,48 @@
+package pulse_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+func TestTokenizers(t *testing.T) {
+	line := `Accepted user_id=42 from 10.0.0.1 dev eth0: path="/var/log/auth.log"`
+	tests := []struct {
+		name      string
+		tokenizer Tokenizer
+		expected  []string
+	}{
+		{"default", DefaultTokenizer{}, []string{"Accepted", "user", "_", "id", "=", "42", "from", "10", ".", "0", ".", "0", ".", "1", "dev", "eth0", ":", "path", "=", "\"", "/", "var", "/", "log", "/", "auth", ".", "log", "\""}},
+		{"whitespace", WhitespaceTokenizer{}, []string{"Accepted", "user_id=42", "from", "10.0.0.1", "dev", "eth0:", `path="/var/log/auth.log"`}},
+		{"structured", StructuredTokenizer{}, []string{"Accepted", "user_id=42", "from", "10.0.0.1", "dev", "eth0", ":", `path="/var/log/auth.log`, "\""}},
+	}
+
+	for _, test := range tests {
+		actual := test.tokenizer.Tokenize(line)
+		if strings.Join(actual, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%s tokenizer does not match", test.name)
+			t.Logf("Expected: %q", test.expected)
+			t.Logf("Actual: %q", actual)
+		}
+	}
+}
+
+func TestStructuredTokenizerKeepsValuesWhole(t *testing.T) {
+	words := []string{
+		"192.168.1.20:8080",
+		"fe80::1ff:fe23:4567:890a",
+		"123e4567-e89b-12d3-a456-426614174000",
+		"00:1a:2b:3c:4d:5e",
+		"0xdeadbeef",
+		"/usr/lib/libc.so.6",
+	}
+	for _, word := range words {
+		tokens := StructuredTokenizer{}.Tokenize("value " + word + " seen")
+		if len(tokens) != 3 || tokens[1] != word {
+			t.Errorf("%s was not kept as one token", word)
+			t.Logf("Actual: %q", tokens)
+		}
+	}
+}
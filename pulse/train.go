@@ -0,0 +1,65 @@
+package pulse
+
+import "context"
+
+//Train learns patterns from the lines read from in without reporting anything, so a baseline can be
+//built from logs that are known to be good.  It returns once in is closed, having also analyzed the
+//event Options.Multiline was assembling.  Lines left unmatched by training are never reported.
+//A trained detector, or one loaded from a trained model, reports every anomaly it finds afterwards
+//instead of waiting for the rate of new patterns to settle.  Train learns even when Options.Freeze is set.
+func (d *Detector) Train(in <-chan string) {
+	d.TrainRecords(records(context.Background(), in))
+}
+
+//TrainRecords learns from records the way Train learns from lines.  With Options.SplitBySource or
+//SplitByLabel each record trains the model of its source or label.
+func (d *Detector) TrainRecords(in <-chan Record) {
+	//children created while training copy the flag from the parent
+	d.mu.Lock()
+	d.training = true
+	for _, c := range d.childList() {
+		c.mu.Lock()
+		c.training = true
+		c.mu.Unlock()
+	}
+	d.mu.Unlock()
+
+	for r := range in {
+		d.AnalyzeRecord(r)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range d.childList() {
+		c.mu.Lock()
+		c.endTraining()
+		c.mu.Unlock()
+	}
+	d.endTraining()
+}
+
+//analyzes the event that was still being assembled and marks the model as trained, the caller must hold the lock
+func (d *Detector) endTraining() {
+	if d.events != nil {
+		if event := d.events.flush(); event != nil {
+			d.analyze(event)
+		}
+	}
+	d.training = false
+	d.trained = true
+}
+
+//returns true if the model must not change, see Options.Freeze
+func (d *Detector) frozen() bool {
+	return d.opts.Freeze && !d.training
+}
+
+//returns true if matching the tokens alignPattern returned would turn a word of the pattern into a wildcard
+func revises(pat *pattern, newTokens []token) bool {
+	for i := range newTokens {
+		if newTokens[i].variable && !pat.tokens[i].variable {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package pulse_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/gophergala2016/Pulse/pulse"
+)
+
+//trains a new detector on logins, with one odd line that stays unmatched
+func trainLogins(opts Options, anomalies *[]Anomaly) *Detector {
+	d := New(opts)
+	d.SetHandler(func(a Anomaly) { *anomalies = append(*anomalies, a) })
+	in := make(chan string)
+	go func() {
+		for i := 0; i < 40; i++ {
+			in <- fmt.Sprintf("user u%d logged in from host h%d", i, i)
+		}
+		in <- "cron job started"
+		close(in)
+	}()
+	d.Train(in)
+	return d
+}
+
+func TestTrainReportsNothing(t *testing.T) {
+	var anomalies []Anomaly
+	d := trainLogins(DefaultOptions(), &anomalies)
+	if len(anomalies) != 0 {
+		t.Errorf("Training reported %d anomalies", len(anomalies))
+	}
+
+	d.Analyze("kernel panic not syncing")
+	d.Flush()
+	if len(anomalies) != 1 || anomalies[0].Line != "kernel panic not syncing" {
+		t.Errorf("A trained detector should report the first odd line, and only it")
+		t.Logf("Expected: kernel panic not syncing")
+		t.Logf("Actual: %v", anomalies)
+	}
+}
+
+func TestFreezeKeepsPatterns(t *testing.T) {
+	var anomalies []Anomaly
+	opts := DefaultOptions()
+	opts.Freeze = true
+	d := trainLogins(opts, &anomalies)
+	before := d.Memory()
+
+	d.Analyze("user u7 logged in from host h7")
+	//would turn "in" into a wildcard
+	d.Analyze("user u8 logged out from host h8")
+	d.Analyze("disk sda1 is full")
+	d.Analyze("disk sda2 is full")
+
+	after := d.Memory()
+	if after.Patterns != before.Patterns || after.Tokens != before.Tokens || after.Unmatched != before.Unmatched {
+		t.Errorf("A frozen model changed")
+		t.Logf("Expected: %d patterns, %d tokens, %d unmatched", before.Patterns, before.Tokens, before.Unmatched)
+		t.Logf("Actual: %d patterns, %d tokens, %d unmatched", after.Patterns, after.Tokens, after.Unmatched)
+	}
+	if len(anomalies) != 3 {
+		t.Errorf("Expected 3 anomalies, got %d", len(anomalies))
+	}
+}